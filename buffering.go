@@ -0,0 +1,49 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"log"
+	"net/http"
+)
+
+// proxyHeaders are request headers set by proxies, which might buffer the
+// response.
+var proxyHeaders = []string{"Via", "Forwarded", "X-Forwarded-For"}
+
+// unwrapFlusher returns the first http.Flusher found by unwrapping w, following
+// the Unwrap convention of http.ResponseController.
+func unwrapFlusher(w http.ResponseWriter) (http.Flusher, bool) {
+	for {
+		u, ok := w.(interface {
+			Unwrap() http.ResponseWriter
+		})
+		if !ok {
+			return nil, false
+		}
+		w = u.Unwrap()
+		if fl, ok := w.(http.Flusher); ok {
+			return fl, true
+		}
+	}
+}
+
+// checkBuffering logs a warning for every sign that the response to r is
+// buffered somewhere along its way, see WithBufferingCheck.
+func checkBuffering(w http.ResponseWriter, r *http.Request, unwrapped bool) {
+	var trace string
+	if id := TraceID(r); id != "" {
+		trace = " [trace " + id + "]"
+	}
+	if unwrapped {
+		log.Printf("sse: %T does not implement http.Flusher, falling back to the unwrapped writer; events might be buffered by the wrapper%s", w, trace)
+	}
+	for _, header := range proxyHeaders {
+		if r.Header.Get(header) != "" {
+			log.Printf("sse: request from %s was proxied (%s header present); make sure the proxy does not buffer the response%s", r.RemoteAddr, header, trace)
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// mockWrappingWriter wraps a http.ResponseWriter without implementing
+// http.Flusher itself, like many logging middlewares.
+type mockWrappingWriter struct {
+	http.ResponseWriter
+}
+
+func (m mockWrappingWriter) Unwrap() http.ResponseWriter {
+	return m.ResponseWriter
+}
+
+func captureLog() *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	return &buf
+}
+
+func TestBufferingCheck(t *testing.T) {
+	buf := captureLog()
+	defer log.SetOutput(os.Stderr)
+
+	streamer := MustNew(WithBufferingCheck())
+
+	// a Flusher without proxy headers is fine
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, NewMockChanWriteFlusher(), r, cancel)
+	stop()
+	if buf.Len() != 0 {
+		t.Error("expected no warning, got:", buf.String())
+	}
+
+	// the unwrapped flusher has to be used as a fallback
+	w := NewMockChanWriteFlusher()
+	r, cancel = NewMockRequest()
+	stop = serve(t, streamer, mockWrappingWriter{w}, r, cancel)
+	streamer.SendString("", "", "fallback")
+	if got := recv(t, w.writes); got != "data:fallback\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+	stop()
+	if !strings.Contains(buf.String(), "does not implement http.Flusher") {
+		t.Error("expected a warning about the fallback, got:", buf.String())
+	}
+
+	// proxied requests
+	buf.Reset()
+	r, cancel = NewMockRequest()
+	r.Header.Set("Via", "1.1 proxy")
+	stop = serve(t, streamer, NewMockChanWriteFlusher(), r, cancel)
+	stop()
+	if !strings.Contains(buf.String(), "Via header") {
+		t.Error("expected a warning about the proxy, got:", buf.String())
+	}
+}
+
+func TestBufferingCheckDisabled(t *testing.T) {
+	buf := captureLog()
+	defer log.SetOutput(os.Stderr)
+
+	streamer := MustNew()
+	r, cancel := NewMockRequest()
+	r.Header.Set("Via", "1.1 proxy")
+	stop := serve(t, streamer, NewMockChanWriteFlusher(), r, cancel)
+	stop()
+
+	// writers which do not implement http.Flusher are not unwrapped
+	rec := httptest.NewRecorder()
+	r, cancel = NewMockRequest()
+	defer cancel()
+	streamer.ServeHTTP(mockWrappingWriter{rec}, r)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("expected status %d, got: %d", http.StatusNotImplemented, rec.Code)
+	}
+	if buf.Len() != 0 {
+		t.Error("expected no warning, got:", buf.String())
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DebugHandler returns a http.Handler which serves a JSON snapshot of the
+// state of the Streamer for debugging, e.g. mounted at an admin path: the
+// connected clients, the Stats and the configuration.
+// The snapshot contains client IDs and subscriptions, thus the handler must
+// be protected by the authentication of the application.
+func (s *Streamer) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(s.debugInfo())
+	})
+}
+
+// debugInfo is the JSON document served by DebugHandler.
+type debugInfo struct {
+	Stats   debugStats    `json:"stats"`
+	Config  debugConfig   `json:"config"`
+	Clients []debugClient `json:"clients"`
+}
+
+type debugStats struct {
+	Clients             int    `json:"clients"`
+	Dropped             uint64 `json:"dropped"`
+	MaxClientDropped    uint64 `json:"maxClientDropped"`
+	QueuedEvents        int    `json:"queuedEvents"`
+	AvgTimeToFirstEvent string `json:"avgTimeToFirstEvent"`
+	MaxTimeToFirstEvent string `json:"maxTimeToFirstEvent"`
+}
+
+type debugConfig struct {
+	BufSize          uint64 `json:"bufSize"`
+	Direct           bool   `json:"direct"`
+	EventQueueSize   int    `json:"eventQueueSize"`
+	Shards           int    `json:"shards"`
+	Overflow         string `json:"overflow"`
+	MaxQueuedBytes   int64  `json:"maxQueuedBytes"`
+	Retry            string `json:"retry"`
+	Heartbeat        string `json:"heartbeat"`
+	MaxConnectionAge string `json:"maxConnectionAge"`
+	IdleTimeout      string `json:"idleTimeout"`
+	ReconnectGrace   string `json:"reconnectGrace"`
+	TopicParam       string `json:"topicParam,omitempty"`
+	History          bool   `json:"history"`
+	Framing          string `json:"framing"`
+}
+
+type debugClient struct {
+	ID         string    `json:"id,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	Connected  time.Time `json:"connected"`
+	Framing    string    `json:"framing"`
+	Buffered   int       `json:"buffered"`
+	BufferSize int       `json:"bufferSize"`
+	Sent       uint64    `json:"sent"`
+	Dropped    uint64    `json:"dropped"`
+	Paused     bool      `json:"paused"`
+	Topics     []string  `json:"topics,omitempty"`
+}
+
+// debugInfo returns a snapshot of the state of the Streamer, see
+// DebugHandler.
+func (s *Streamer) debugInfo() debugInfo {
+	stats := s.Stats()
+	info := debugInfo{
+		Stats: debugStats{
+			Clients:             stats.Clients,
+			Dropped:             stats.Dropped,
+			MaxClientDropped:    stats.MaxClientDropped,
+			QueuedEvents:        stats.QueuedEvents,
+			AvgTimeToFirstEvent: stats.AvgTimeToFirstEvent.String(),
+			MaxTimeToFirstEvent: stats.MaxTimeToFirstEvent.String(),
+		},
+		Config: debugConfig{
+			BufSize:          atomic.LoadUint64(&s.bufSize),
+			Direct:           s.direct,
+			EventQueueSize:   s.queueSize,
+			Shards:           len(s.shards),
+			Overflow:         "block",
+			MaxQueuedBytes:   s.maxQueuedBytes,
+			Retry:            time.Duration(atomic.LoadInt64(&s.retry)).String(),
+			Heartbeat:        s.heartbeat.String(),
+			MaxConnectionAge: s.maxAge.String(),
+			IdleTimeout:      s.idleTimeout.String(),
+			ReconnectGrace:   s.reconnectGrace.String(),
+			TopicParam:       s.topicParam,
+			History:          s.history != nil,
+			Framing:          framingName(s.framing),
+		},
+		Clients: []debugClient{},
+	}
+	if s.overflow == OverflowDrop {
+		info.Config.Overflow = "drop"
+	}
+
+	s.query(func() {
+		for cl := range s.clients {
+			c := debugClient{
+				ID:         cl.id,
+				Group:      cl.group,
+				Connected:  cl.since,
+				Framing:    framingName(cl.framing),
+				Buffered:   len(cl.ch) + len(cl.prio),
+				BufferSize: cap(cl.ch),
+				Sent:       cl.sent,
+				Dropped:    cl.dropped,
+				Paused:     atomic.LoadInt32(&cl.paused) != 0,
+			}
+			if cl.subs != nil {
+				for topic := range cl.subs.topics {
+					c.Topics = append(c.Topics, topic)
+				}
+				for _, prefix := range cl.subs.prefixes {
+					c.Topics = append(c.Topics, prefix+"*")
+				}
+				sort.Strings(c.Topics)
+			}
+			info.Clients = append(info.Clients, c)
+		}
+	})
+	return info
+}
+
+// framingName returns the name of the framing in the debug snapshot.
+func framingName(framing Framing) string {
+	if framing == FramingJSONLines {
+		return "jsonlines"
+	}
+	return "sse"
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDebugHandler(t *testing.T) {
+	streamer := MustNew(
+		WithTopicParam("topic"),
+		WithHeartbeat(time.Minute),
+		WithClientID(func(r *http.Request) string {
+			return r.Header.Get("X-Client")
+		}),
+	)
+	for _, id := range []string{"a", "b"} {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-Client", id)
+		r.URL.RawQuery = "topic=news,orders.*&topic=" + id
+		defer serve(t, streamer, w, r, cancel)()
+	}
+	streamer.PauseClient("b")
+
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/debug/sse", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamer.DebugHandler().ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got: %q", ct)
+	}
+
+	var info struct {
+		Stats struct {
+			Clients int `json:"clients"`
+		} `json:"stats"`
+		Config struct {
+			Heartbeat  string `json:"heartbeat"`
+			TopicParam string `json:"topicParam"`
+		} `json:"config"`
+		Clients []struct {
+			ID        string    `json:"id"`
+			Connected time.Time `json:"connected"`
+			Paused    bool      `json:"paused"`
+			Topics    []string  `json:"topics"`
+		} `json:"clients"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+
+	if info.Stats.Clients != 2 {
+		t.Errorf("expected 2 clients in the stats, got: %d", info.Stats.Clients)
+	}
+	if info.Config.Heartbeat != "1m0s" || info.Config.TopicParam != "topic" {
+		t.Errorf("unexpected config: %+v", info.Config)
+	}
+	if len(info.Clients) != 2 {
+		t.Fatalf("expected 2 clients, got: %d", len(info.Clients))
+	}
+	for _, cl := range info.Clients {
+		expected := []string{cl.ID, "news", "orders.*"}
+		if !reflect.DeepEqual(cl.Topics, expected) {
+			t.Errorf("client %q: expected topics %v, got: %v", cl.ID, expected, cl.Topics)
+		}
+		if cl.Paused != (cl.ID == "b") {
+			t.Errorf("client %q: unexpected paused state %v", cl.ID, cl.Paused)
+		}
+		if cl.Connected.IsZero() || time.Since(cl.Connected) > time.Minute {
+			t.Errorf("client %q: unexpected connect time %v", cl.ID, cl.Connected)
+		}
+	}
+}
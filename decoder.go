@@ -0,0 +1,161 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// Decoder reads and decodes Server-Sent Events from an input stream.
+type Decoder struct {
+	r       *bufio.Reader
+	started bool // the first line was read
+	skipLF  bool // the previous line ended with a CR
+
+	unescape bool // see SingleLineData
+	maxLine  int  // see MaxLineLength
+}
+
+// defaultMaxLine is the default maximum line length of a Decoder.
+const defaultMaxLine = 1 << 20
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:       bufio.NewReader(r),
+		maxLine: defaultMaxLine,
+	}
+}
+
+// MaxLineLength limits the length of the lines read by the decoder to n bytes,
+// excluding the line ending. Decode returns ErrLineTooLong for longer lines,
+// after which the decoder must not be used anymore. By default, lines are
+// limited to 1 MiB. A limit of 0 disables it.
+func (d *Decoder) MaxLineLength(n int) {
+	d.maxLine = n
+}
+
+// SingleLineData makes the decoder unescape the data of events, which were
+// sent with escaped newlines in a single data field, see WithSingleLineData.
+func (d *Decoder) SingleLineData() {
+	d.unescape = true
+}
+
+// bom is the UTF-8 byte order mark, which may precede the stream.
+var bom = []byte("\xEF\xBB\xBF")
+
+// readLine reads a single line without the trailing line ending.
+// Lines may end with CRLF, LF or CR. A byte order mark at the beginning of
+// the stream is stripped.
+func (d *Decoder) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				// an unterminated line at the end of the stream is discarded
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		if d.skipLF {
+			// the LF of a CRLF line ending
+			d.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+
+		switch b {
+		case '\r':
+			d.skipLF = true
+		case '\n':
+		default:
+			if d.maxLine > 0 && len(line) >= d.maxLine {
+				return nil, ErrLineTooLong
+			}
+			line = append(line, b)
+			continue
+		}
+
+		if !d.started {
+			d.started = true
+			line = bytes.TrimPrefix(line, bom)
+		}
+		return line, nil
+	}
+}
+
+// Decode reads the next event from the stream.
+// Comments and events without any data are skipped. Events which are not
+// terminated by a blank line before the end of the stream are discarded.
+// At the end of the stream, Decode returns io.EOF. If a line exceeds the
+// maximum line length, see MaxLineLength, it returns ErrLineTooLong.
+func (d *Decoder) Decode() (e Event, err error) {
+	var data []byte
+	hasData := false
+
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return Event{}, err
+		}
+
+		// A blank line dispatches the event
+		if len(line) == 0 {
+			if !hasData {
+				e = Event{}
+				continue
+			}
+			e.Data = data
+			if d.unescape {
+				e.Data = unescapeData(data)
+			}
+			return e, nil
+		}
+
+		// Lines starting with a colon are comments
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			if len(value) > 0 && value[0] == ' ' {
+				value = value[1:]
+			}
+		}
+
+		switch string(field) {
+		case "event":
+			e.Event = string(value)
+		case "data":
+			if hasData {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+			hasData = true
+		case "id":
+			e.ID = string(value)
+		default:
+			// unknown fields are ignored
+		}
+	}
+}
+
+// DataLines splits the data of a decoded event into the data fields of the
+// event, i.e. it is the inverse of sending an event with SendLines or
+// SendDataLines. Data without any newline is a single line; empty data is a
+// single empty line.
+func DataLines(data []byte) [][]byte {
+	return bytes.Split(data, []byte("\n"))
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeAll(t *testing.T, stream string) []Event {
+	dec := NewDecoder(strings.NewReader(stream))
+	var events []Event
+	for {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		events = append(events, e)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	var tests = []struct {
+		stream string
+		events []Event
+	}{
+		{"", nil},
+		{"data:Test\n\n", []Event{{Data: []byte("Test")}}},
+		{"data: Test\n\n", []Event{{Data: []byte("Test")}}},
+		{"data:  Test\n\n", []Event{{Data: []byte(" Test")}}},
+		{"data\n\n", []Event{{}}},
+		{"event:msg\ndata:Hi!\n\n", []Event{{Event: "msg", Data: []byte("Hi!")}}},
+		{"id:1\nevent:msg\ndata:a\ndata:\ndata:b\n\n", []Event{{ID: "1", Event: "msg", Data: []byte("a\n\nb")}}},
+		{"data:crlf\r\n\r\n", []Event{{Data: []byte("crlf")}}},
+		{": comment\ndata:x\n\n", []Event{{Data: []byte("x")}}},
+		{"event:nodata\n\ndata:x\n\n", []Event{{Data: []byte("x")}}},
+		{"unknown:field\ndata:x\n\n", []Event{{Data: []byte("x")}}},
+		{"data:a\n\ndata:b\n\n", []Event{{Data: []byte("a")}, {Data: []byte("b")}}},
+		{"data:incomplete\n", nil},
+		{"data:incomplete", nil},
+
+		// byte order mark
+		{"\xEF\xBB\xBFdata:bom\n\n", []Event{{Data: []byte("bom")}}},
+		{"\xEF\xBB\xBF\xEF\xBB\xBFdata:bom\n\ndata:x\n\n", []Event{{Data: []byte("x")}}},
+		{"data:\xEF\xBB\xBFx\n\n", []Event{{Data: []byte("\xEF\xBB\xBFx")}}},
+
+		// line endings
+		{"data:cr\r\r", []Event{{Data: []byte("cr")}}},
+		{"data:a\rdata:b\n\r\n", []Event{{Data: []byte("a\nb")}}},
+		{"data:a\r\n\rdata:b\r\r", []Event{{Data: []byte("a")}, {Data: []byte("b")}}},
+
+		// field parsing
+		{"data:\n\n", []Event{{}}},
+		{"data: \n\n", []Event{{}}},
+		{"data:a:b\n\n", []Event{{Data: []byte("a:b")}}},
+		{"event\ndata:x\n\n", []Event{{Data: []byte("x")}}},
+		{"id\nid:1\ndata:x\n\n", []Event{{ID: "1", Data: []byte("x")}}},
+		{"Data:x\n\n", nil},
+		{"data :x\n\n", nil},
+		{"retry:1000\ndata:x\n\n", []Event{{Data: []byte("x")}}},
+		{":\n:comment\n: data:y\ndata:x\n\n", []Event{{Data: []byte("x")}}},
+	}
+
+	for _, test := range tests {
+		events := decodeAll(t, test.stream)
+		if !reflect.DeepEqual(events, test.events) {
+			t.Errorf("decoding %q, expected: %+v, got: %+v", test.stream, test.events, events)
+		}
+	}
+}
+
+func TestDecoderMaxLineLength(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("data:12\n\ndata:123\n\n"))
+	dec.MaxLineLength(len("data:12"))
+	e, err := dec.Decode()
+	if err != nil || string(e.Data) != "12" {
+		t.Fatalf("expected the event within the limit, got: %+v, %v", e, err)
+	}
+	if _, err = dec.Decode(); err != ErrLineTooLong {
+		t.Error("expected ErrLineTooLong, got:", err)
+	}
+
+	// the default limit
+	dec = NewDecoder(strings.NewReader("data:" + strings.Repeat("x", defaultMaxLine) + "\n\n"))
+	if _, err = dec.Decode(); err != ErrLineTooLong {
+		t.Error("expected ErrLineTooLong, got:", err)
+	}
+
+	// unlimited
+	dec = NewDecoder(strings.NewReader("data:" + strings.Repeat("x", defaultMaxLine) + "\n\n"))
+	dec.MaxLineLength(0)
+	if e, err = dec.Decode(); err != nil || len(e.Data) != defaultMaxLine {
+		t.Errorf("expected the event without a limit, got %d bytes, %v", len(e.Data), err)
+	}
+}
+
+func TestDataLinesRoundTrip(t *testing.T) {
+	streamer := MustNew()
+
+	var tests = []struct {
+		lines    []string
+		stream   string
+		restored []string
+	}{
+		// an empty slice is restored as a single empty line
+		{nil, "data\n\n", []string{""}},
+		{[]string{}, "data\n\n", []string{""}},
+		{[]string{""}, "data\n\n", []string{""}},
+
+		{[]string{"a"}, "data:a\n\n", []string{"a"}},
+		{[]string{"a", "b"}, "data:a\ndata:b\n\n", []string{"a", "b"}},
+		{[]string{"", ""}, "data:\ndata:\n\n", []string{"", ""}},
+		{[]string{"a", "", "b"}, "data:a\ndata:\ndata:b\n\n", []string{"a", "", "b"}},
+		{[]string{"", "a", ""}, "data:\ndata:a\ndata:\n\n", []string{"", "a", ""}},
+		{[]string{"a:b", "data:c"}, "data:a:b\ndata:data:c\n\n", []string{"a:b", "data:c"}},
+	}
+
+	for _, test := range tests {
+		lines := make([][]byte, len(test.lines))
+		for i, line := range test.lines {
+			lines[i] = []byte(line)
+		}
+		stream := string(streamer.formatLines("", "", lines))
+		if stream != test.stream {
+			t.Errorf("lines %q: expected stream %q, got: %q", test.lines, test.stream, stream)
+		}
+
+		events := decodeAll(t, stream)
+		if len(events) != 1 {
+			t.Fatalf("lines %q: expected 1 event, got: %d", test.lines, len(events))
+		}
+		var restored []string
+		for _, line := range DataLines(events[0].Data) {
+			restored = append(restored, string(line))
+		}
+		if !reflect.DeepEqual(restored, test.restored) {
+			t.Errorf("lines %q: expected restored lines %q, got: %q", test.lines, test.restored, restored)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"time"
+)
+
+// WithDedup enables the deduplication of events by their ID: an event whose
+// ID was already sent within the given window is discarded, e.g. when a
+// producer retries sending in an at-least-once pipeline.
+// Events without an ID are never discarded. SendEventResult reports
+// discarded events with ErrDuplicate.
+func WithDedup(window time.Duration) Option {
+	return func(s *Streamer) {
+		s.dedup = &dedup{
+			window: window,
+			ids:    make(map[string]time.Time),
+			now:    time.Now,
+		}
+	}
+}
+
+// dedup tracks the IDs of recently sent events, see WithDedup.
+// It is owned by the run goroutine.
+type dedup struct {
+	window time.Duration
+	ids    map[string]time.Time // time when each ID was first seen
+	order  []dedupEntry         // IDs ordered by the time they were seen
+	now    func() time.Time
+}
+
+type dedupEntry struct {
+	id string
+	at time.Time
+}
+
+// seen reports whether the ID was already seen within the window. Otherwise
+// the ID is recorded.
+func (d *dedup) seen(id string) bool {
+	now := d.now()
+
+	// Forget expired IDs
+	i := 0
+	for ; i < len(d.order) && now.Sub(d.order[i].at) > d.window; i++ {
+		delete(d.ids, d.order[i].id)
+	}
+	if i > 0 {
+		d.order = append(d.order[:0], d.order[i:]...)
+	}
+
+	if _, ok := d.ids[id]; ok {
+		return true
+	}
+	d.ids[id] = now
+	d.order = append(d.order, dedupEntry{id, now})
+	return false
+}
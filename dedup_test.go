@@ -0,0 +1,73 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	streamer := MustNew(WithDirectBroadcast(), WithDedup(time.Minute))
+	now := time.Now()
+	streamer.dedup.now = func() time.Time {
+		return now
+	}
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.SendString("1", "", "a")
+	streamer.SendString("1", "", "duplicate") // within the window
+	streamer.SendString("", "", "no id")
+	streamer.SendString("", "", "no id")
+	streamer.SendString("2", "", "b")
+
+	// events are dispatched synchronously in direct broadcast mode
+	now = now.Add(2 * time.Minute)
+	streamer.SendString("1", "", "after the window")
+
+	for _, expected := range []string{
+		"id:1\ndata:a\n\n",
+		"data:no id\n\n",
+		"data:no id\n\n",
+		"id:2\ndata:b\n\n",
+		"id:1\ndata:after the window\n\n",
+	} {
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+}
+
+func TestDedupResult(t *testing.T) {
+	streamer := MustNew(WithDedup(time.Minute), WithClientID(func(r *http.Request) string {
+		return "a"
+	}))
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	defer serve(t, streamer, w, r, cancel)()
+
+	if errs := streamer.SendEventResult(Event{ID: "1", Data: []byte("a")}); len(errs) != 0 {
+		t.Errorf("expected no errors, got: %v", errs)
+	}
+	if got := recv(t, w.writes); got != "id:1\ndata:a\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+
+	errs := streamer.SendEventResult(Event{ID: "1", Data: []byte("duplicate")})
+	if len(errs) != 1 || errs[0].ClientID != "a" || errs[0].Err != ErrDuplicate {
+		t.Errorf("expected the duplicate to be reported, got: %v", errs)
+	}
+	select {
+	case got := <-w.writes:
+		t.Errorf("the duplicate was written: %q", got)
+	default:
+	}
+}
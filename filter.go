@@ -0,0 +1,51 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"strings"
+)
+
+// WithEventTypeParam enables clients to limit the event types they receive
+// with the given query parameter, e.g. "?events=a,b" for the param "events".
+// The parameter may be repeated or contain a comma-separated list, e.g.
+// "?events=a&events=b". Events without an event type are received by clients
+// which include the type "message", which is the type EventSource clients
+// dispatch them as. Event types include the prefix, see WithEventPrefix.
+// Clients without the parameter receive all events.
+// Only broadcast events are filtered: the events written when a client
+// connects, batches and raw bytes are not, see SendBatch and SendRaw.
+func WithEventTypeParam(param string) Option {
+	return func(s *Streamer) {
+		s.typeParam = param
+	}
+}
+
+// parseEventTypes parses the event types from the query parameter of the
+// request. It returns nil if the client receives all event types.
+func parseEventTypes(r *http.Request, param string) map[string]bool {
+	var types map[string]bool
+	for _, value := range r.URL.Query()[param] {
+		for _, eventType := range strings.Split(value, ",") {
+			if eventType == "" {
+				continue
+			}
+			if types == nil {
+				types = make(map[string]bool)
+			}
+			types[eventType] = true
+		}
+	}
+	return types
+}
+
+// eventType returns the event type of the message as dispatched by clients.
+func (s *Streamer) eventType(m *message) string {
+	if m.e.Event == "" {
+		return "message"
+	}
+	return s.prefix + m.e.Event
+}
@@ -0,0 +1,50 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"testing"
+)
+
+func TestEventTypeParam(t *testing.T) {
+	streamer := MustNew(WithEventTypeParam("events"), WithEventPrefix("app."))
+
+	var writers = make(map[string]mockChanWriteFlusher)
+	for _, query := range []string{"events=app.a,app.b", "events=app.c&events=message", "events=a", ""} {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.URL.RawQuery = query
+		stop := serve(t, streamer, w, r, cancel)
+		defer stop()
+		writers[query] = w
+	}
+
+	streamer.SendString("", "a", "a")
+	streamer.SendString("", "b", "b")
+	streamer.SendString("", "c", "c")
+	streamer.SendString("", "", "untyped")
+	streamer.SendBatch(Event{Event: "d", Data: []byte("batch")})
+
+	var (
+		a       = "event:app.a\ndata:a\n\n"
+		b       = "event:app.b\ndata:b\n\n"
+		c       = "event:app.c\ndata:c\n\n"
+		untyped = "data:untyped\n\n"
+		batch   = "event:app.d\ndata:batch\n\n"
+	)
+	var expected = map[string][]string{
+		"events=app.a,app.b":          {a, b, batch},
+		"events=app.c&events=message": {c, untyped, batch},
+		"events=a":                    {batch},
+		"":                            {a, b, c, untyped, batch},
+	}
+	for query, w := range writers {
+		for _, event := range expected[query] {
+			if got := recv(t, w.writes); got != event {
+				t.Errorf("%q: expected %q, got: %q", query, event, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Framing is the format in which events are streamed to a client.
+type Framing int
+
+const (
+	// FramingSSE streams Server-Sent Events. This is the default.
+	FramingSSE Framing = iota
+
+	// FramingJSONLines streams each event as a JSON object on its own line,
+	// prefixed with the length of the object in bytes and a space, e.g.
+	//
+	//	27 {"event":"msg","data":"hi"}
+	//
+	// The data is a JSON string. Empty lines are sent as heartbeats.
+	// The media type is JSONLinesContentType.
+	FramingJSONLines
+)
+
+// JSONLinesContentType is the media type of FramingJSONLines.
+const JSONLinesContentType = "application/x-ndjson"
+
+// WithFraming enables an alternative framing for clients which are not
+// EventSource clients, e.g. CLI tools or other services. Clients explicitly
+// accepting the media type of the framing in their Accept header are served
+// with it, all other clients are served Server-Sent Events.
+func WithFraming(framing Framing) Option {
+	return func(s *Streamer) {
+		s.framing = framing
+	}
+}
+
+// WithFramingSelector sets a function which selects the framing of each
+// connecting client, e.g. by a query parameter or a header other than Accept,
+// instead of the negotiation of WithFraming. Unknown framings are served as
+// FramingSSE.
+// Like with WithFraming, each event is serialized once per framing, not once
+// per client, regardless of the number of clients.
+func WithFramingSelector(selector func(r *http.Request) Framing) Option {
+	return func(s *Streamer) {
+		s.selectFraming = selector
+	}
+}
+
+// negotiateFraming returns the framing for the client of the request.
+func (s *Streamer) negotiateFraming(r *http.Request) Framing {
+	if s.selectFraming != nil {
+		if s.selectFraming(r) == FramingJSONLines {
+			return FramingJSONLines
+		}
+		return FramingSSE
+	}
+	if s.framing != FramingJSONLines {
+		return FramingSSE
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err == nil && mediaType == JSONLinesContentType {
+			return FramingJSONLines
+		}
+	}
+	return FramingSSE
+}
+
+// jsonLine is the JSON object of an event in FramingJSONLines.
+type jsonLine struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event,omitempty"`
+	Data  string `json:"data"`
+}
+
+// frameJSON serializes the event in FramingJSONLines, or returns nil if the
+// event can not be encoded.
+func (s *Streamer) frameJSON(e Event) []byte {
+	if e.Event != "" {
+		e.Event = s.prefix + e.Event
+	}
+	obj, err := s.marshalJSON(jsonLine{e.ID, e.Event, string(e.Data)})
+	if err != nil {
+		return nil
+	}
+	p := strconv.AppendInt(nil, int64(len(obj)), 10)
+	p = append(p, ' ')
+	p = append(p, obj...)
+	return append(p, '\n')
+}
+
+// frame serializes the event in the given framing.
+func (s *Streamer) frame(framing Framing, e Event) []byte {
+	if framing == FramingJSONLines {
+		return s.frameJSON(e)
+	}
+	if p := s.formatBytes(e.ID, e.Event, e.Data); p != nil {
+		return s.routed(e, p)
+	}
+	return nil
+}
+
+// bytes returns the serialized event in the given framing, or nil if the
+// message has no representation in it, e.g. a reconnection time hint.
+func (m *message) bytes(framing Framing) []byte {
+	if framing == FramingJSONLines {
+		return m.frame
+	}
+	return m.event
+}
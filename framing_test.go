@@ -0,0 +1,86 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFraming(t *testing.T) {
+	var tests = []struct {
+		framing     Framing
+		accept      string
+		contentType string
+		expected    string
+	}{
+		{FramingJSONLines, "application/x-ndjson", JSONLinesContentType,
+			"36 {\"id\":\"1\",\"event\":\"msg\",\"data\":\"hi\"}\n"},
+		{FramingJSONLines, "text/plain, application/x-ndjson;q=0.9", JSONLinesContentType,
+			"36 {\"id\":\"1\",\"event\":\"msg\",\"data\":\"hi\"}\n"},
+		{FramingJSONLines, "text/event-stream", "text/event-stream",
+			"id:1\nevent:msg\ndata:hi\n\n"},
+		{FramingJSONLines, "", "text/event-stream",
+			"id:1\nevent:msg\ndata:hi\n\n"},
+		{FramingSSE, "application/x-ndjson", "text/event-stream",
+			"id:1\nevent:msg\ndata:hi\n\n"},
+	}
+	for _, test := range tests {
+		streamer := MustNew(WithFraming(test.framing))
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		stop := serve(t, streamer, w, r, cancel)
+
+		streamer.SendString("1", "msg", "hi")
+		if got := recv(t, w.writes); got != test.expected {
+			t.Errorf("Accept %q: expected %q, got: %q", test.accept, test.expected, got)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("Accept %q: expected Content-Type %q, got: %q", test.accept, test.contentType, ct)
+		}
+		stop()
+	}
+}
+
+func TestFramingSelector(t *testing.T) {
+	streamer := MustNew(WithFramingSelector(func(r *http.Request) Framing {
+		if r.URL.Query().Get("format") == "ndjson" {
+			return FramingJSONLines
+		}
+		return FramingSSE
+	}))
+
+	var tests = []struct {
+		query       string
+		contentType string
+		expected    string
+	}{
+		{"format=ndjson", JSONLinesContentType, "36 {\"id\":\"1\",\"event\":\"msg\",\"data\":\"hi\"}\n"},
+		{"", "text/event-stream", "id:1\nevent:msg\ndata:hi\n\n"},
+	}
+	var writers []mockChanWriteFlusher
+	for _, test := range tests {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.URL.RawQuery = test.query
+		// the Accept header is not negotiated
+		r.Header.Set("Accept", JSONLinesContentType)
+		defer serve(t, streamer, w, r, cancel)()
+		writers = append(writers, w)
+	}
+
+	streamer.SendString("1", "msg", "hi")
+	for i, test := range tests {
+		if got := recv(t, writers[i].writes); got != test.expected {
+			t.Errorf("%q: expected %q, got: %q", test.query, test.expected, got)
+		}
+		if ct := writers[i].Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("%q: expected Content-Type %q, got: %q", test.query, test.contentType, ct)
+		}
+	}
+}
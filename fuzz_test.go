@@ -0,0 +1,105 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// checkEvent validates the framing of a single serialized event: every line
+// must be a well-formed id, event or data field terminated by the line ending,
+// and the event must be terminated by exactly one blank line.
+func checkEvent(p []byte, lineEnding string) error {
+	le := []byte(lineEnding)
+	end := append(append([]byte(nil), le...), le...)
+	if !bytes.HasSuffix(p, end) {
+		return errors.New("event is not terminated by a blank line")
+	}
+
+	lines := bytes.Split(p[:len(p)-len(le)], le)
+	lines = lines[:len(lines)-1] // empty after the last line ending
+	for i, line := range lines {
+		if len(line) == 0 {
+			return fmt.Errorf("line %d: unexpected blank line", i)
+		}
+		if bytes.ContainsAny(line, "\r\n") {
+			return fmt.Errorf("line %d: stray line break in %q", i, line)
+		}
+		switch {
+		case bytes.Equal(line, []byte("data")):
+		case bytes.HasPrefix(line, []byte("data:")):
+		case bytes.HasPrefix(line, []byte("id:")):
+		case bytes.HasPrefix(line, []byte("event:")):
+		default:
+			return fmt.Errorf("line %d: malformed field %q", i, line)
+		}
+	}
+	return nil
+}
+
+// roundTrip validates the framing of the serialized event p and decodes it.
+func roundTrip(t *testing.T, p []byte, lineEnding string) Event {
+	if err := checkEvent(p, lineEnding); err != nil {
+		t.Fatalf("%v: %q", err, p)
+	}
+	e, err := NewDecoder(bytes.NewReader(p)).Decode()
+	if err != nil {
+		t.Fatalf("decoding %q failed: %v", p, err)
+	}
+	return e
+}
+
+// roundTrips reports whether a field value survives the round trip through a
+// client. Line breaks end the field and clients strip a single leading space
+// after the colon, thus such values can not be sent.
+func roundTrips(value string) bool {
+	return !strings.ContainsAny(value, "\r\n") && !strings.HasPrefix(value, " ")
+}
+
+func FuzzFormat(f *testing.F) {
+	f.Add("", "", "", int64(0))
+	f.Add("1", "msg", "hello", int64(42))
+	f.Add("id", "", "a\nb\n", int64(-1))
+	f.Add("", "event", "\n\n", int64(-9223372036854775808))
+	f.Add("42", "update", "{\"a\":1}", int64(9223372036854775807))
+	f.Add("x", "y", "\xEF\xBB\xBFdata", int64(7))
+
+	f.Fuzz(func(t *testing.T, id, event, data string, n int64) {
+		if !roundTrips(id) || !roundTrips(event) {
+			t.Skip()
+		}
+		for _, line := range strings.Split(data, "\n") {
+			if !roundTrips(line) {
+				t.Skip()
+			}
+		}
+
+		for _, lineEnding := range []string{"\n", "\r\n"} {
+			s := MustNew(WithDirectBroadcast(), WithLineEnding(lineEnding))
+			expected := Event{ID: id, Event: event, Data: []byte(data)}
+			check := func(name string, p []byte, expected Event) {
+				e := roundTrip(t, p, lineEnding)
+				if e.ID != expected.ID || e.Event != expected.Event || !bytes.Equal(e.Data, expected.Data) {
+					t.Errorf("%s: expected %+v, got: %+v", name, expected, e)
+				}
+			}
+
+			check("formatBytes", s.formatBytes(id, event, []byte(data)), expected)
+			check("formatString", s.formatString(id, event, data), expected)
+			check("formatLines", s.formatLines(id, event, bytes.Split([]byte(data), []byte("\n"))), expected)
+
+			expected.Data = strconv.AppendInt(nil, n, 10)
+			check("formatInt", s.formatInt(id, event, n), expected)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"time"
+)
+
+// WithReconnectGrace holds the slot of a client which went away for the
+// duration d, e.g. to bridge the brief connection losses of mobile clients.
+// During this period, its buffered events are kept and the events sent to it
+// are collected, up to its buffer size. If a client with the same ID
+// reconnects within this period, it is sent these events before any other
+// event, otherwise they are discarded. Clients are identified by their ID, see
+// WithClientID; clients without an ID and clients disconnected by the server,
+// e.g. by DisconnectClient or Close, are not held.
+// Only the latest connection of each ID is held. If a history is set and the
+// reconnecting client sends a Last-Event-ID, the missed events are replayed
+// from the history instead, see WithHistory.
+func WithReconnectGrace(d time.Duration) Option {
+	return func(s *Streamer) {
+		s.reconnectGrace = d
+	}
+}
+
+// heldClient is the slot of a client which went away, held for a reconnect,
+// see WithReconnectGrace.
+type heldClient struct {
+	cl     *client  // the client which went away, for addressing events
+	events [][]byte // in the framing of cl
+	timer  *time.Timer
+}
+
+// holds reports whether the slot of the client is held for a reconnect when it
+// goes away.
+func (s *Streamer) holds(cl *client) bool {
+	if s.reconnectGrace <= 0 || cl.id == "" {
+		return false
+	}
+	select {
+	case <-cl.evicted:
+		return false
+	default:
+		return true
+	}
+}
+
+// holdSlot holds the slot of a client which was just unregistered, so that
+// events sent during the grace period are collected for it.
+// It must be called from the run goroutine.
+func (s *Streamer) holdSlot(cl *client) {
+	if s.closing || !s.holds(cl) {
+		return
+	}
+	if old := s.held[cl.id]; old != nil {
+		old.timer.Stop()
+	}
+	h := &heldClient{cl: cl}
+	s.held[cl.id] = h
+	h.timer = time.AfterFunc(s.reconnectGrace, func() {
+		s.query(func() {
+			if s.held[cl.id] == h {
+				delete(s.held, cl.id)
+			}
+		})
+	})
+}
+
+// holdEvent collects a dispatched event for all held slots it is addressed
+// to. Events exceeding the buffer size of a client are dropped.
+func (s *Streamer) holdEvent(m *message, eventType string) {
+	for _, h := range s.held {
+		if m.group != "" && h.cl.group != m.group {
+			continue
+		}
+		if len(h.events) >= cap(h.cl.ch) || !s.addressed(h.cl, m, eventType) {
+			continue
+		}
+		if p := m.bytes(h.cl.framing); p != nil {
+			h.events = append(h.events, p)
+		}
+	}
+}
+
+// hold keeps the events drained by unregister and the buffered events of an
+// unregistered client in its held slot, before the events collected since.
+func (s *Streamer) hold(cl *client, drained []message) {
+	if !s.holds(cl) {
+		// the client was evicted meanwhile
+		for _, m := range drained {
+			m.result.reply(cl, ErrClientGone)
+		}
+		return
+	}
+
+	// The kept events are not delivered yet and may never be, thus they are
+	// reported as not received, see SendEventResult
+	var buffered [][]byte
+	keep := func(m message) {
+		if p := s.take(cl, m); p != nil {
+			buffered = append(buffered, p)
+		}
+		m.result.reply(cl, ErrClientGone)
+	}
+	for _, m := range drained {
+		keep(m)
+	}
+buffered:
+	for {
+		var m message
+		select {
+		case m = <-cl.prio:
+		default:
+			select {
+			case m = <-cl.prio:
+			case m = <-cl.ch:
+			default:
+				break buffered
+			}
+		}
+		keep(m)
+	}
+	if len(buffered) == 0 {
+		return
+	}
+	s.query(func() {
+		if h := s.held[cl.id]; h != nil && h.cl == cl {
+			h.events = append(buffered, h.events...)
+		}
+	})
+}
+
+// resumeHeld returns the events of the held slot of a reconnecting client, if
+// any. If replay is set, the missed events are replayed from the history
+// instead.
+func (s *Streamer) resumeHeld(cl *client, replay bool) [][]byte {
+	h := s.held[cl.id]
+	if h == nil || cl.id == "" {
+		return nil
+	}
+	delete(s.held, cl.id)
+	h.timer.Stop()
+	if replay || h.cl.framing != cl.framing {
+		return nil
+	}
+	return h.events
+}
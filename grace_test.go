@@ -0,0 +1,119 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReconnectGrace(t *testing.T) {
+	const grace = 100 * time.Millisecond
+	streamer := MustNew(
+		WithReconnectGrace(grace),
+		WithClientID(func(r *http.Request) string {
+			return r.Header.Get("X-Client")
+		}),
+	)
+	// the held slot collects events up to the buffer size
+	streamer.BufSize(4)
+	connect := func() (mockChanWriteFlusher, func()) {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-Client", "a")
+		return w, serve(t, streamer, w, r, cancel)
+	}
+	// goAway disconnects the client with the given events buffered
+	goAway := func(stop func(), data ...string) {
+		streamer.PauseClient("a")
+		// wait until the client loop noticed the pause
+		waitFor(t, func() bool {
+			var woken bool
+			streamer.query(func() {
+				for cl := range streamer.clients {
+					woken = len(cl.wake) == 0
+				}
+			})
+			return woken
+		})
+		for _, d := range data {
+			streamer.SendString("", "", d)
+		}
+		waitFor(t, func() bool {
+			metrics := streamer.ClientMetrics()
+			return len(metrics) == 1 && metrics[0].Buffered == len(data)
+		})
+		stop()
+	}
+
+	_, stop := connect()
+	goAway(stop, "1", "2")
+	streamer.SendString("", "", "missed")
+
+	// the buffered events and the events sent meanwhile are sent when
+	// reconnecting within the grace period
+	w, stop := connect()
+	streamer.SendString("", "", "3")
+	for _, expected := range []string{"data:1\n\ndata:2\n\ndata:missed\n\n", "data:3\n\n"} {
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+	goAway(stop, "4")
+
+	// the buffered events are discarded after the grace period
+	waitFor(t, func() bool {
+		var held int
+		streamer.query(func() {
+			held = len(streamer.held)
+		})
+		return held == 0
+	})
+	w, stop = connect()
+	defer stop()
+	streamer.SendString("", "", "5")
+	if got := recv(t, w.writes); got != "data:5\n\n" {
+		t.Errorf("expected only the live event, got: %q", got)
+	}
+}
+
+func TestReconnectGraceResult(t *testing.T) {
+	streamer := MustNew(
+		WithReconnectGrace(time.Minute),
+		WithClientID(func(r *http.Request) string {
+			return "a"
+		}),
+	)
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	streamer.PauseClient("a")
+	waitFor(t, func() bool {
+		var woken bool
+		streamer.query(func() {
+			for cl := range streamer.clients {
+				woken = len(cl.wake) == 0
+			}
+		})
+		return woken
+	})
+
+	done := make(chan []ClientError)
+	go func() {
+		done <- streamer.SendEventResult(Event{Data: []byte("kept")})
+	}()
+	waitFor(t, func() bool {
+		metrics := streamer.ClientMetrics()
+		return len(metrics) == 1 && metrics[0].Buffered == 1
+	})
+	stop()
+
+	// the kept event is not reported as received
+	errs := <-done
+	if len(errs) != 1 || errs[0].ClientID != "a" || errs[0].Err != ErrClientGone {
+		t.Errorf("expected ErrClientGone for the client, got: %v", errs)
+	}
+}
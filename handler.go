@@ -0,0 +1,133 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultIdleTimeout is the default IdleTimeout of a Handler.
+const DefaultIdleTimeout = 30 * time.Second
+
+// Handler is a http.Handler which serves each request with a Streamer selected
+// by a key derived from the request, e.g. one Streamer per tenant or chat room.
+// Streamers are created lazily when the first client of a key connects and are
+// closed and discarded once no client of the key was connected for the
+// IdleTimeout.
+type Handler struct {
+	// IdleTimeout is the time a Streamer is kept after its last client
+	// disconnected, so that reconnecting clients and producers holding the
+	// Streamer continue to use it, together with its state like the history.
+	// If it is zero, emptied Streamers are closed immediately.
+	// NewHandler sets it to DefaultIdleTimeout.
+	IdleTimeout time.Duration
+
+	key    func(r *http.Request) string
+	create func(key string) *Streamer
+
+	mu        sync.Mutex
+	streamers map[string]*handlerEntry
+}
+
+type handlerEntry struct {
+	streamer *Streamer
+	refs     int         // number of requests being served
+	idle     *time.Timer // closes the Streamer once it is idle, see IdleTimeout
+	gen      int         // incremented whenever the entry becomes idle
+}
+
+// NewHandler returns a new Handler. The key function selects the Streamer for
+// each request. The create function is called to create the Streamer for a key
+// which currently has no Streamer.
+func NewHandler(key func(r *http.Request) string, create func(key string) *Streamer) *Handler {
+	return &Handler{
+		IdleTimeout: DefaultIdleTimeout,
+		key:         key,
+		create:      create,
+		streamers:   make(map[string]*handlerEntry),
+	}
+}
+
+// Streamer returns the current Streamer for the given key, e.g. to send events
+// to its clients. If no client of the key was connected within the
+// IdleTimeout, it returns nil.
+// Producers should look up the Streamer for each send instead of keeping it,
+// as an idle Streamer is closed and replaced by a new one for the next client.
+func (h *Handler) Streamer(key string) *Streamer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if entry := h.streamers[key]; entry != nil {
+		return entry.streamer
+	}
+	return nil
+}
+
+// acquire returns the Streamer for the given key, creating it if necessary,
+// and increments its reference count.
+func (h *Handler) acquire(key string) *Streamer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry := h.streamers[key]
+	if entry == nil {
+		entry = &handlerEntry{streamer: h.create(key)}
+		h.streamers[key] = entry
+	}
+	if entry.idle != nil {
+		entry.idle.Stop()
+		entry.idle = nil
+	}
+	entry.refs++
+	return entry.streamer
+}
+
+// release decrements the reference count of the Streamer for the given key.
+// The Streamer is closed and discarded when it was no longer referenced for
+// the IdleTimeout.
+func (h *Handler) release(key string) {
+	h.mu.Lock()
+	entry := h.streamers[key]
+	entry.refs--
+	if entry.refs > 0 {
+		h.mu.Unlock()
+		return
+	}
+	if h.IdleTimeout > 0 {
+		entry.gen++
+		gen := entry.gen
+		entry.idle = time.AfterFunc(h.IdleTimeout, func() {
+			h.expire(key, entry, gen)
+		})
+		h.mu.Unlock()
+		return
+	}
+	delete(h.streamers, key)
+	h.mu.Unlock()
+
+	entry.streamer.Close()
+}
+
+// expire closes and discards the Streamer of the entry unless it was acquired
+// again since it became idle in the given generation.
+func (h *Handler) expire(key string, entry *handlerEntry, gen int) {
+	h.mu.Lock()
+	if h.streamers[key] != entry || entry.refs > 0 || entry.gen != gen {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.streamers, key)
+	h.mu.Unlock()
+
+	entry.streamer.Close()
+}
+
+// ServeHTTP implements http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := h.key(r)
+	s := h.acquire(key)
+	defer h.release(key)
+	s.ServeHTTP(w, r)
+}
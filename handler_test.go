@@ -0,0 +1,153 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	created := make(map[string]int)
+	h := NewHandler(func(r *http.Request) string {
+		return r.Header.Get("X-Room")
+	}, func(key string) *Streamer {
+		created[key]++ // called with the lock of the handler held
+		return MustNew()
+	})
+	h.IdleTimeout = 0 // close emptied Streamers immediately
+
+	type conn struct {
+		w    mockChanWriteFlusher
+		stop func()
+	}
+	connect := func(room string) conn {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-Room", room)
+		done := make(chan struct{})
+		go func() {
+			h.ServeHTTP(w, r)
+			close(done)
+		}()
+		waitFor(t, func() bool {
+			s := h.Streamer(room)
+			return s != nil && s.ClientCount() > 0
+		})
+		return conn{w, func() {
+			cancel()
+			<-done
+		}}
+	}
+
+	a1 := connect("a")
+	a := h.Streamer("a")
+	a2 := connect("a")
+	waitForClients(t, a, 2)
+	b := connect("b")
+
+	// selection and reuse
+	if h.Streamer("a") != a {
+		t.Fatal("expected the Streamer to be reused")
+	}
+	if h.Streamer("a") == h.Streamer("b") {
+		t.Fatal("expected different Streamers for different keys")
+	}
+	if created["a"] != 1 || created["b"] != 1 {
+		t.Error("expected one Streamer per key, got:", created)
+	}
+
+	h.Streamer("a").SendString("", "", "a")
+	h.Streamer("b").SendString("", "", "b")
+	for _, c := range []conn{a1, a2} {
+		if got := recv(t, c.w.writes); got != "data:a\n\n" {
+			t.Errorf("wrong event, got: %q", got)
+		}
+	}
+	if got := recv(t, b.w.writes); got != "data:b\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+
+	// cleanup of emptied Streamers
+	a1.stop()
+	if h.Streamer("a") != a {
+		t.Fatal("expected the Streamer to be kept while a client is connected")
+	}
+	a2.stop()
+	if h.Streamer("a") != nil {
+		t.Fatal("expected the emptied Streamer to be discarded")
+	}
+	w := NewMockResponseWriteFlusher()
+	r, cancel := NewMockRequest()
+	a.ServeHTTP(w, r)
+	cancel()
+	if w.status != http.StatusServiceUnavailable {
+		t.Error("expected the emptied Streamer to be closed, got status:", w.status)
+	}
+
+	// a new Streamer is created for the next client
+	a3 := connect("a")
+	if h.Streamer("a") == a || created["a"] != 2 {
+		t.Error("expected a new Streamer")
+	}
+	a3.stop()
+	b.stop()
+}
+
+func TestHandlerIdleTimeout(t *testing.T) {
+	h := NewHandler(func(r *http.Request) string {
+		return "a"
+	}, func(key string) *Streamer {
+		return MustNew()
+	})
+	h.IdleTimeout = time.Minute
+
+	connect := func() (w mockChanWriteFlusher, stop func()) {
+		w = NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		done := make(chan struct{})
+		go func() {
+			h.ServeHTTP(w, r)
+			close(done)
+		}()
+		waitFor(t, func() bool {
+			s := h.Streamer("a")
+			return s != nil && s.ClientCount() > 0
+		})
+		return w, func() {
+			cancel()
+			<-done
+		}
+	}
+
+	// a producer holding the Streamer reaches the reconnected client
+	_, stop := connect()
+	producer := h.Streamer("a")
+	stop()
+	waitForClients(t, producer, 0)
+	w, stop := connect()
+	if h.Streamer("a") != producer {
+		t.Fatal("expected the idle Streamer to be reused")
+	}
+	producer.SendString("", "", "reconnected")
+	if got := recv(t, w.writes); got != "data:reconnected\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+
+	// the Streamer is closed once it was idle for the timeout
+	h.mu.Lock()
+	h.IdleTimeout = 10 * time.Millisecond
+	h.mu.Unlock()
+	stop()
+	waitFor(t, func() bool {
+		return h.Streamer("a") == nil
+	})
+	select {
+	case <-producer.closed:
+	case <-time.After(2 * time.Second):
+		t.Error("expected the idle Streamer to be closed")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrUnknownEventID is returned by a HistoryStore if the requested event ID is
+// not (or no longer) known.
+var ErrUnknownEventID = errors.New("sse: unknown event ID")
+
+// HistoryStore stores broadcast events, which are replayed to reconnecting
+// clients sending a Last-Event-ID header. See WithHistoryStore.
+//
+// Both methods are called from the goroutine broadcasting the events and
+// therefore should return quickly.
+type HistoryStore interface {
+	// Append adds an event to the history. Events sent only to some clients,
+	// e.g. with SendToGroup, are not added.
+	Append(e Event)
+
+	// Since returns all events appended after the event with the given ID, in
+	// order. If the ID is unknown, ErrUnknownEventID is returned.
+	Since(id string) ([]Event, error)
+}
+
+// WithHistoryStore sets a store for the history of broadcast events.
+// Clients reconnecting with a Last-Event-ID header first receive all events
+// they missed since that ID, after any retained events. If the store returns
+// an error, e.g. because the ID is unknown, no events are replayed.
+func WithHistoryStore(store HistoryStore) Option {
+	return func(s *Streamer) {
+		s.history = store
+	}
+}
+
+// WithHistory keeps the last size broadcast events in memory for replay.
+// It is a shorthand for WithHistoryStore(NewMemoryHistory(size)).
+func WithHistory(size int) Option {
+	return WithHistoryStore(NewMemoryHistory(size))
+}
+
+// WithAutoID enables assigning consecutive numeric IDs, starting at 1, to all
+// broadcast events without an ID, so that clients can resume the stream with
+// the Last-Event-ID, see WithHistory. It is a shorthand for WithInitialID(0).
+func WithAutoID() Option {
+	return WithInitialID(0)
+}
+
+// WithInitialID enables assigning consecutive numeric IDs to all broadcast
+// events without an ID, like WithAutoID, but continues the numbering after the
+// given ID, i.e. the first event is sent with the ID id+1. Passing the value of
+// LastID persisted before a restart keeps the IDs monotonic across restarts of
+// the Streamer, which replaying missed events relies on.
+// The IDs increase in the order in which events are broadcast. Batches are not
+// assigned IDs, see SendBatch.
+func WithInitialID(id uint64) Option {
+	return func(s *Streamer) {
+		s.autoID = true
+		s.lastID = id
+	}
+}
+
+// LastID returns the ID assigned to the latest broadcast event, or the initial
+// ID if none was assigned yet, see WithInitialID.
+func (s *Streamer) LastID() uint64 {
+	return atomic.LoadUint64(&s.lastID)
+}
+
+// assignID assigns the next ID to the message, see WithInitialID.
+// It must be called in the run goroutine.
+func (s *Streamer) assignID(m *message) {
+	id := strconv.FormatUint(atomic.AddUint64(&s.lastID, 1), 10)
+	m.e.ID = id
+	p := make([]byte, 0, 3+len(id)+len(s.lineEnding)+len(m.event))
+	p = append(p, "id:"...)
+	p = append(p, id...)
+	p = append(p, s.lineEnding...)
+	m.event = append(p, m.event...)
+	if m.frame != nil {
+		m.frame = s.frameJSON(m.e)
+	}
+}
+
+// WithResumeResolver enables resuming streams with a token of the
+// application's own sequencing scheme instead of the Last-Event-ID. Clients
+// connecting with the given request header, e.g. "X-Resume-Token", first
+// receive the events resolve returns for the header value, e.g. all events
+// the client missed since the token was issued.
+// The function is called after the client was connected, thus no live event
+// is missed while it runs. It is not called for clients without the header.
+func WithResumeResolver(header string, resolve func(token string) []Event) Option {
+	return func(s *Streamer) {
+		s.resumeHeader = header
+		s.resume = resolve
+	}
+}
+
+// MemoryHistory is a HistoryStore keeping a fixed number of the latest events
+// in memory.
+type MemoryHistory struct {
+	mu     sync.Mutex
+	events []Event // ring buffer
+	next   int     // index of the next event to be written
+	full   bool
+}
+
+// NewMemoryHistory returns a new MemoryHistory keeping the last size events.
+func NewMemoryHistory(size int) *MemoryHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &MemoryHistory{
+		events: make([]Event, size),
+	}
+}
+
+// Append adds an event to the history, evicting the oldest event if the
+// history is full.
+func (h *MemoryHistory) Append(e Event) {
+	h.mu.Lock()
+	h.events[h.next] = e
+	h.next++
+	if h.next == len(h.events) {
+		h.next = 0
+		h.full = true
+	}
+	h.mu.Unlock()
+}
+
+// Since returns all events after the latest event with the given ID.
+func (h *MemoryHistory) Since(id string) ([]Event, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// events in chronological order
+	events := h.events[:h.next]
+	if h.full {
+		events = append(append([]Event(nil), h.events[h.next:]...), events...)
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].ID == id {
+			return append([]Event(nil), events[i+1:]...), nil
+		}
+	}
+	return nil, ErrUnknownEventID
+}
@@ -0,0 +1,170 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemoryHistorySince(t *testing.T) {
+	h := NewMemoryHistory(3)
+	if _, err := h.Since("1"); err != ErrUnknownEventID {
+		t.Error("expected ErrUnknownEventID for an empty history, got:", err)
+	}
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		h.Append(Event{ID: id, Data: []byte(id)})
+	}
+
+	var tests = []struct {
+		id     string
+		events []string
+		err    error
+	}{
+		{"1", nil, ErrUnknownEventID}, // evicted
+		{"2", []string{"3", "4"}, nil},
+		{"3", []string{"4"}, nil},
+		{"4", nil, nil},
+		{"5", nil, ErrUnknownEventID},
+	}
+	for _, test := range tests {
+		events, err := h.Since(test.id)
+		if err != test.err {
+			t.Errorf("Since(%q): expected error %v, got: %v", test.id, test.err, err)
+		}
+		var ids []string
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		if !reflect.DeepEqual(ids, test.events) {
+			t.Errorf("Since(%q): expected events %v, got: %v", test.id, test.events, ids)
+		}
+	}
+}
+
+func TestHistoryReplay(t *testing.T) {
+	streamer := MustNew(WithDirectBroadcast(), WithHistory(10))
+	streamer.SendString("1", "", "a")
+	streamer.SendString("2", "msg", "b")
+	streamer.SendToGroup("group", Event{ID: "g", Data: []byte("hidden")})
+	streamer.SendString("3", "", "c")
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	r.Header.Set("Last-Event-ID", "1")
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	// the missed events are written at once
+	expected := "id:2\nevent:msg\ndata:b\n\nid:3\ndata:c\n\n"
+	if data := recv(t, w.writes); data != expected {
+		t.Errorf("expected %q, got: %q", expected, data)
+	}
+
+	// an unknown ID replays nothing
+	w2 := NewMockChanWriteFlusher()
+	r2, cancel2 := NewMockRequest()
+	r2.Header.Set("Last-Event-ID", "unknown")
+	stop2 := serve(t, streamer, w2, r2, cancel2)
+	defer stop2()
+
+	streamer.SendString("4", "", "d")
+	if data := recv(t, w2.writes); data != "id:4\ndata:d\n\n" {
+		t.Error("expected only the live event, got:", data)
+	}
+}
+
+func TestHistoryBatch(t *testing.T) {
+	h := NewMemoryHistory(10)
+	streamer := MustNew(WithDirectBroadcast(), WithHistoryStore(h))
+	streamer.SendBatch(Event{ID: "1", Data: []byte("a")}, Event{ID: "2", Data: []byte("b")})
+
+	events, err := h.Since("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ID != "2" || string(events[0].Data) != "b" {
+		t.Error("expected the second event of the batch, got:", events)
+	}
+}
+
+func TestResumeResolver(t *testing.T) {
+	tokens := make(chan string, 2)
+	streamer := MustNew(WithResumeResolver("X-Resume-Token", func(token string) []Event {
+		tokens <- token
+		return []Event{
+			{ID: "a", Data: []byte("missed 1")},
+			{ID: "b", Data: []byte("missed 2")},
+		}
+	}))
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	r.Header.Set("X-Resume-Token", "token-7")
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	if token := <-tokens; token != "token-7" {
+		t.Errorf("expected the token of the client, got: %q", token)
+	}
+	streamer.SendString("", "", "live")
+	for _, expected := range []string{"id:a\ndata:missed 1\n\nid:b\ndata:missed 2\n\n", "data:live\n\n"} {
+		if data := recv(t, w.writes); data != expected {
+			t.Errorf("expected %q, got: %q", expected, data)
+		}
+	}
+
+	// clients without a token are not resumed
+	w2 := NewMockChanWriteFlusher()
+	r2, cancel2 := NewMockRequest()
+	defer serve(t, streamer, w2, r2, cancel2)()
+	streamer.SendString("", "", "live")
+	if data := recv(t, w2.writes); data != "data:live\n\n" {
+		t.Error("expected only the live event, got:", data)
+	}
+	if len(tokens) > 0 {
+		t.Error("unexpected call of the resolver for a client without token")
+	}
+}
+
+func TestInitialID(t *testing.T) {
+	streamer := MustNew(WithDirectBroadcast(), WithInitialID(41), WithHistory(10))
+	if id := streamer.LastID(); id != 41 {
+		t.Errorf("expected the initial ID, got: %d", id)
+	}
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+
+	streamer.SendString("", "msg", "a")
+	streamer.SendString("custom", "", "b")
+	// the ID is assigned after the copy is returned
+	if p := streamer.SendEvent(Event{Data: []byte("c")}); string(p) != "data:c\n\n" {
+		t.Errorf("expected the copy without the assigned ID, got: %q", p)
+	}
+	for _, expected := range []string{
+		"id:42\nevent:msg\ndata:a\n\n",
+		"id:custom\ndata:b\n\n",
+		"id:43\ndata:c\n\n",
+	} {
+		if data := recv(t, w.writes); data != expected {
+			t.Errorf("expected %q, got: %q", expected, data)
+		}
+	}
+	stop()
+	if id := streamer.LastID(); id != 43 {
+		t.Errorf("expected the last assigned ID, got: %d", id)
+	}
+
+	// the assigned IDs are replayed
+	w = NewMockChanWriteFlusher()
+	r, cancel = NewMockRequest()
+	r.Header.Set("Last-Event-ID", "42")
+	defer serve(t, streamer, w, r, cancel)()
+	if data := recv(t, w.writes); data != "id:custom\ndata:b\n\nid:43\ndata:c\n\n" {
+		t.Errorf("expected the missed events, got: %q", data)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// ErrLineTooLong is returned for events with a data line exceeding the maximum
+// line length, see WithMaxLineLength. It is also returned by a Decoder for
+// lines exceeding its maximum line length, see Decoder.MaxLineLength.
+var ErrLineTooLong = errors.New("sse: data line too long")
+
+// LinePolicy determines what happens with events with a data line exceeding
+// the maximum line length.
+type LinePolicy int
+
+const (
+	// LineReject discards the event. Send methods returning an error, like
+	// Send or SendJSON, return ErrLineTooLong. This is the default.
+	LineReject LinePolicy = iota
+
+	// LineSplit splits long lines into multiple data fields of the maximum
+	// length. Note that clients join data fields with a newline, thus they
+	// receive the data with additional newlines. The data must be able to
+	// cope with that, e.g. JSON split between tokens is still valid, but JSON
+	// split within a string is not.
+	LineSplit
+)
+
+// WithMaxLineLength limits the length of the data lines of events to n bytes,
+// excluding the "data:" field name, since some clients and intermediaries
+// reject very long lines. The policy determines what happens with events
+// exceeding the limit.
+// A length of 0 disables the limit.
+func WithMaxLineLength(n int, policy LinePolicy) Option {
+	return func(s *Streamer) {
+		s.maxLineLength = n
+		s.linePolicy = policy
+	}
+}
+
+// longestLine returns the length of the longest line of data.
+func longestLine(data string) (max int) {
+	for {
+		n := strings.IndexByte(data, '\n')
+		if n < 0 {
+			if len(data) > max {
+				max = len(data)
+			}
+			return
+		}
+		if n > max {
+			max = n
+		}
+		data = data[n+1:]
+	}
+}
+
+// longestLineBytes returns the length of the longest line of data.
+func longestLineBytes(data []byte) (max int) {
+	for {
+		n := bytes.IndexByte(data, '\n')
+		if n < 0 {
+			if len(data) > max {
+				max = len(data)
+			}
+			return
+		}
+		if n > max {
+			max = n
+		}
+		data = data[n+1:]
+	}
+}
+
+// splitLines inserts a newline into every line of data after each n bytes.
+func splitLines(data string, n int) string {
+	var buf bytes.Buffer
+	line := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c == '\n' {
+			line = 0
+		} else {
+			if line == n {
+				buf.WriteByte('\n')
+				line = 0
+			}
+			line++
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
+
+// WithSingleLineData enables sending the data of every event in a single data
+// field, even if it contains newlines, for clients which parse only a single
+// data line per event. Newlines, carriage returns and backslashes in the data
+// are escaped as the two characters \n, \r and \\, like in JSON strings, which
+// clients must unescape, see Decoder.SingleLineData. EventSource clients
+// receive the escaped data.
+// Events sent with SendLines or SendDataLines, whose lines are explicitly
+// separate data fields, are not affected.
+func WithSingleLineData() Option {
+	return func(s *Streamer) {
+		s.singleLine = true
+	}
+}
+
+// dataEscaper escapes data for a single data field, see WithSingleLineData.
+var dataEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+
+// unescapeData reverses the escaping of dataEscaper. Unknown escape
+// sequences and a trailing backslash are kept as is.
+func unescapeData(data []byte) []byte {
+	if bytes.IndexByte(data, '\\') < 0 {
+		return data
+	}
+	unescaped := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == '\\' && i+1 < len(data) {
+			switch data[i+1] {
+			case 'n':
+				unescaped = append(unescaped, '\n')
+				i++
+				continue
+			case 'r':
+				unescaped = append(unescaped, '\r')
+				i++
+				continue
+			case '\\':
+				unescaped = append(unescaped, '\\')
+				i++
+				continue
+			}
+		}
+		unescaped = append(unescaped, data[i])
+	}
+	return unescaped
+}
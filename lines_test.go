@@ -0,0 +1,151 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLongestLine(t *testing.T) {
+	var tests = []struct {
+		data    string
+		longest int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"a\nabc\nab", 3},
+		{"abc\n", 3},
+		{"\n\n", 0},
+	}
+	for _, test := range tests {
+		if n := longestLine(test.data); n != test.longest {
+			t.Errorf("longestLine(%q): expected %d, got: %d", test.data, test.longest, n)
+		}
+		if n := longestLineBytes([]byte(test.data)); n != test.longest {
+			t.Errorf("longestLineBytes(%q): expected %d, got: %d", test.data, test.longest, n)
+		}
+	}
+}
+
+func TestMaxLineLengthReject(t *testing.T) {
+	streamer := MustNew(WithMaxLineLength(3, LineReject))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	if err := streamer.Send("", "", "abcd"); err != ErrLineTooLong {
+		t.Error("Send: expected ErrLineTooLong, got:", err)
+	}
+	if err := streamer.Send("", "", []byte("ab\nabcd")); err != ErrLineTooLong {
+		t.Error("Send: expected ErrLineTooLong, got:", err)
+	}
+	if err := streamer.SendJSON("", "", "abcd"); err != ErrLineTooLong {
+		t.Error("SendJSON: expected ErrLineTooLong, got:", err)
+	}
+	if err := streamer.SendStringCtx(context.Background(), "", "", "abcd"); err != ErrLineTooLong {
+		t.Error("SendStringCtx: expected ErrLineTooLong, got:", err)
+	}
+	if p := streamer.SendEvent(Event{Data: []byte("abcd")}); p != nil {
+		t.Errorf("SendEvent: expected no event, got: %q", p)
+	}
+	streamer.SendString("", "", "abcd")
+	streamer.SendLines("", "", [][]byte{[]byte("abcd")})
+
+	// only the events within the limit are sent
+	streamer.SendString("", "", "abc\nabc")
+	if got := recv(t, w.writes); got != "data:abc\ndata:abc\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+}
+
+func TestMaxLineLengthSplit(t *testing.T) {
+	streamer := MustNew(WithMaxLineLength(3, LineSplit))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.SendString("", "", "abcdefg\nab")
+	streamer.SendBytes("", "", []byte("abcdef"))
+	streamer.SendLines("", "", [][]byte{[]byte("abcd"), []byte("ab")})
+	if err := streamer.SendJSON("", "", "ab"); err != nil {
+		t.Error("unexpected error:", err)
+	}
+
+	for _, expected := range []string{
+		"data:abc\ndata:def\ndata:g\ndata:ab\n\n",
+		"data:abc\ndata:def\n\n",
+		"data:abc\ndata:d\ndata:ab\n\n",
+		"data:\"ab\ndata:\"\n\n",
+	} {
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+}
+
+func TestSingleLineData(t *testing.T) {
+	streamer := MustNew(WithSingleLineData(), WithLineEnding("\r\n"))
+
+	var tests = []struct {
+		data    string
+		escaped string
+	}{
+		{"", "data\r\n\r\n"},
+		{"plain", "data:plain\r\n\r\n"},
+		{"a\nb\n", "data:a\\nb\\n\r\n\r\n"},
+		{"\r\n", "data:\\r\\n\r\n\r\n"},
+		{`C:\new`, "data:C:\\\\new\r\n\r\n"},
+		{"\\n\n", "data:\\\\n\\n\r\n\r\n"},
+	}
+	for _, test := range tests {
+		for _, p := range [][]byte{
+			streamer.formatString("", "", test.data),
+			streamer.formatBytes("", "", []byte(test.data)),
+		} {
+			if string(p) != test.escaped {
+				t.Errorf("%q: expected %q, got: %q", test.data, test.escaped, p)
+			}
+
+			d := NewDecoder(bytes.NewReader(p))
+			d.SingleLineData()
+			e, err := d.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(e.Data) != test.data {
+				t.Errorf("%q: round trip returned %q", test.data, e.Data)
+			}
+		}
+	}
+
+	// JSON escapes in the data must survive the round trip
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	defer serve(t, streamer, w, r, cancel)()
+	v := map[string]string{"a": "x\ny", "b": `C:\new`}
+	if err := streamer.SendJSON("", "", v); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(strings.NewReader(recv(t, w.writes)))
+	d.SingleLineData()
+	e, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(e.Data, &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", e.Data, err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("JSON round trip: expected %v, got: %v", v, got)
+	}
+}
@@ -0,0 +1,626 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option configures a Streamer. Options are passed to New.
+type Option func(*Streamer)
+
+// invalid records err as the error returned by New, unless an earlier option
+// was already invalid.
+func (s *Streamer) invalid(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// validate checks that the options are coherent, i.e. that options which
+// depend on others are used together and limits are in range.
+func (s *Streamer) validate() error {
+	switch {
+	case s.topicRetained != nil && s.topicParam == "":
+		return errors.New("sse: WithTopicRetention requires WithTopicParam")
+	case s.limitConns != 0 && (s.limitKey == nil || s.limitConns < 1):
+		return errors.New("sse: WithMaxConnectionsPerClient requires a key function and a limit of at least 1")
+	case s.traceEcho != "" && s.traceHeader == "":
+		return errors.New("sse: WithTraceID requires a header to echo the trace ID")
+	case s.queueSize < 0:
+		return errors.New("sse: the event queue size must not be negative")
+	case s.dedup != nil && s.dedup.window <= 0:
+		return errors.New("sse: the deduplication window must be positive")
+	case s.shardCount < 0:
+		return errors.New("sse: the number of shards must not be negative")
+	case s.maxLineLength < 0:
+		return errors.New("sse: the maximum line length must not be negative")
+	case s.reconnectGrace > 0 && s.clientID == nil:
+		return errors.New("sse: WithReconnectGrace requires WithClientID")
+	}
+	return nil
+}
+
+// WithAuthorizer sets a function which is called for every incoming request
+// before the client is connected. If it returns false, the request is answered
+// with the returned HTTP status code and the client is not connected.
+// If the returned status is 0, http.StatusForbidden is used.
+func WithAuthorizer(authorize func(r *http.Request) (authorized bool, status int)) Option {
+	return func(s *Streamer) {
+		s.authorize = authorize
+	}
+}
+
+// WithGroupKey sets a function which assigns each connecting client to a
+// group, e.g. by a tenant or user id derived from the request. Events can be
+// sent to all clients of a group with SendToGroup.
+// Clients for which the function returns an empty string are not assigned to
+// any group.
+func WithGroupKey(key func(r *http.Request) string) Option {
+	return func(s *Streamer) {
+		s.groupKey = key
+	}
+}
+
+// WithClientID sets a function which assigns an ID to each connecting client,
+// e.g. a session or user id derived from the request. Events can be sent to
+// all clients except those with a given ID with SendStringExcept.
+// IDs need not be unique; all clients sharing an ID are excluded together.
+func WithClientID(id func(r *http.Request) string) Option {
+	return func(s *Streamer) {
+		s.clientID = id
+	}
+}
+
+// WithMaxConnectionsPerClient limits the number of simultaneous connections
+// of clients sharing a key to n, e.g. a user id or the remote IP address
+// derived from the request. Requests exceeding the limit are answered with
+// http.StatusTooManyRequests. This keeps a single user from exhausting the
+// server by opening many connections, e.g. in many browser tabs.
+// Clients for which the function returns an empty string are not limited.
+func WithMaxConnectionsPerClient(key func(r *http.Request) string, n int) Option {
+	return func(s *Streamer) {
+		s.limitKey = key
+		s.limitConns = n
+	}
+}
+
+// WithIndexedHeaders sets the request headers which are stored for each
+// connecting client, so that events can be sent only to the clients with a
+// certain header value with SendStringToHeader. Only the first value of each
+// header is stored.
+func WithIndexedHeaders(headers ...string) Option {
+	return func(s *Streamer) {
+		s.headers = make([]string, len(headers))
+		for i, header := range headers {
+			s.headers[i] = http.CanonicalHeaderKey(header)
+		}
+	}
+}
+
+// WithBacklog sets a function which returns a backlog of events for each
+// connecting client, e.g. the last messages of a chat. The backlog is written
+// only to the connecting client, after any retained events and before any live
+// event. The function is called after the client was connected, thus no live
+// event is missed while it runs.
+func WithBacklog(backlog func(r *http.Request) []Event) Option {
+	return func(s *Streamer) {
+		s.backlog = backlog
+	}
+}
+
+// WithConnectPreamble sets events which are written to every client when it
+// connects, e.g. a greeting or the current configuration of the application.
+// The initial bytes of each connection are written in this order before any
+// live event:
+//
+//  1. the padding comment, see WithInitialPadding
+//  2. the reconnection time, see WithRetry
+//  3. the preamble events
+//  4. the retained events, see SendRetained
+//  5. the retained events of the subscribed topics, see WithTopicRetention
+//  6. the events kept for a reconnect, see WithReconnectGrace
+//  7. the events missed since the Last-Event-ID, see WithHistory
+//  8. the events resolved from the resume token, see WithResumeResolver
+//  9. the backlog events, see WithBacklog
+func WithConnectPreamble(events []Event) Option {
+	return func(s *Streamer) {
+		s.preamble = events
+	}
+}
+
+// WithEventPrefix sets a prefix which is prepended to the event type of all
+// sent events, e.g. a tenant or module name. This lets multiple producers
+// share a Streamer without colliding event types.
+// Events without an event type are sent without one, since clients handle
+// those as generic message events, which would change if they were typed.
+func WithEventPrefix(prefix string) Option {
+	return func(s *Streamer) {
+		s.prefix = prefix
+	}
+}
+
+// WithRoutingComment sets a function which returns a routing key for each
+// event, e.g. for frontends which use the event type for display semantics
+// but need an orthogonal key to dispatch events to components. The key is sent
+// in a comment line before the fields of the event, e.g. ":route=foo" if the
+// function returns "route=foo", which EventSource clients ignore, but custom
+// parsers can read. No comment is sent if the function returns an empty
+// string. The key must not contain line breaks, it is cut at the first one.
+// Events sent with SendRaw are not passed to the function.
+func WithRoutingComment(route func(e Event) string) Option {
+	return func(s *Streamer) {
+		s.route = route
+	}
+}
+
+// routed prepends the routing comment of the event to its serialization p,
+// see WithRoutingComment.
+func (s *Streamer) routed(e Event, p []byte) []byte {
+	if s.route == nil {
+		return p
+	}
+	key := s.route(e)
+	if i := strings.IndexAny(key, "\r\n"); i >= 0 {
+		key = key[:i]
+	}
+	if key == "" {
+		return p
+	}
+	routed := make([]byte, 0, 1+len(key)+len(s.lineEnding)+len(p))
+	routed = append(routed, ':')
+	routed = append(routed, key...)
+	routed = append(routed, s.lineEnding...)
+	return append(routed, p...)
+}
+
+// WithContentType sets the Content-Type header sent to clients, e.g. to add a
+// charset parameter: "text/event-stream; charset=utf-8".
+// The default is "text/event-stream". EventSource clients require the media
+// type text/event-stream, thus New returns an error if the value has another
+// media type or can not be parsed.
+func WithContentType(contentType string) Option {
+	return func(s *Streamer) {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "text/event-stream" {
+			s.invalid(errors.New("sse: invalid content type " + strconv.Quote(contentType)))
+			return
+		}
+		s.contentType = contentType
+	}
+}
+
+// WithLineEnding sets the line ending used in the event stream, either "\n"
+// (LF, the default) or "\r\n" (CRLF) for intermediaries or clients requiring
+// it. New returns an error for any other value.
+func WithLineEnding(lineEnding string) Option {
+	return func(s *Streamer) {
+		if lineEnding != "\n" && lineEnding != "\r\n" {
+			s.invalid(errors.New("sse: invalid line ending " + strconv.Quote(lineEnding)))
+			return
+		}
+		s.lineEnding = lineEnding
+	}
+}
+
+// WithJSONMarshaler sets the function used to encode values as JSON, e.g. in
+// SendJSON. The default is json.Marshal.
+// The encoded JSON must not contain newlines.
+func WithJSONMarshaler(marshal func(v interface{}) ([]byte, error)) Option {
+	return func(s *Streamer) {
+		s.marshal = marshal
+	}
+}
+
+// WithAutoEventType sets a function which derives the event type from the
+// value passed to Send if no event type is given, e.g. TypeName for apps where
+// each Go type maps to a named event. If the function returns an empty string,
+// the event is sent without an event type. Other send methods are not
+// affected.
+func WithAutoEventType(eventType func(v interface{}) string) Option {
+	return func(s *Streamer) {
+		s.autoEventType = eventType
+	}
+}
+
+// TypeName returns the name of the type of v, dereferencing pointers, e.g.
+// "OrderCreated" for both OrderCreated{} and &OrderCreated{}. It returns an
+// empty string for nil and for unnamed types. It can be used with
+// WithAutoEventType.
+func TypeName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
+
+// Observer is notified about the clients and events of a Streamer, e.g. to
+// record metrics or traces. See the otelsse package for an implementation
+// using OpenTelemetry.
+// The methods of the Observer must be fast, since they are called in the
+// broadcast path.
+type Observer interface {
+	// Connect is called when a client connected with the given request.
+	// The returned function, if not nil, is called when the client
+	// disconnected.
+	Connect(r *http.Request) (disconnected func())
+
+	// Broadcast is called for every sent event with the number of clients the
+	// event is sent to, i.e. only those addressed by it, e.g. the subscribers
+	// of its topic. It is not called for reconnection times, pings and raw
+	// bytes, see SetRetry, Ping and SendRaw.
+	Broadcast(clients int)
+}
+
+// WithObserver sets an Observer, which is notified about the clients and
+// events of the Streamer.
+func WithObserver(observer Observer) Option {
+	return func(s *Streamer) {
+		s.observer = observer
+	}
+}
+
+// WithSendObserver sets a function which is called for every sent event right
+// before it is passed to the clients, with the number of clients it is passed
+// to, e.g. for audit logging or metrics. Unlike Observer, it receives the
+// event itself, including its data. The events of a batch are passed
+// individually. Reconnection times and raw bytes are not passed.
+// The function is called in the broadcast path, thus it must be fast and must
+// not block, e.g. by passing the event to another goroutine. It must not
+// modify the event.
+func WithSendObserver(observe func(e Event, clients int)) Option {
+	return func(s *Streamer) {
+		s.sendObserver = observe
+	}
+}
+
+// GoneCause is the way in which a client was detected to have gone away, see
+// WithOnClientGone.
+type GoneCause int
+
+const (
+	// GoneCanceled means the request context was canceled, e.g. because the
+	// user navigated away and the browser closed the connection.
+	GoneCanceled GoneCause = iota + 1
+
+	// GoneWriteError means writing to the connection failed, e.g. because the
+	// connection broke.
+	GoneWriteError
+)
+
+// WithOnClientGone sets a function which is called when a client went away,
+// with the ID of the client (see WithClientID), its request and the cause.
+// Unlike the disconnect notification of an Observer, it is not called for
+// connections ended by the server, e.g. by Close or WithMaxConnectionAge, thus
+// it can be used to e.g. update the presence of a user or to release a lock
+// held for the connection.
+// The function is called before the client is disconnected.
+func WithOnClientGone(f func(clientID string, r *http.Request, cause GoneCause)) Option {
+	return func(s *Streamer) {
+		s.onClientGone = f
+	}
+}
+
+// WithHTTP10 allows HTTP/1.0 requests. By default, they are answered with
+// http.StatusHTTPVersionNotSupported, since HTTP/1.0 clients commonly wait for
+// the end of the response, which never comes, instead of processing the
+// stream. Some reverse proxies however forward requests as HTTP/1.0 while
+// streaming the response to the client correctly, e.g. nginx without
+// "proxy_http_version 1.1". Such deployments must set this option.
+func WithHTTP10() Option {
+	return func(s *Streamer) {
+		s.allowHTTP10 = true
+	}
+}
+
+// WithBufferingCheck enables logging a warning for each connecting client
+// whose events are likely buffered on their way, which makes them arrive in
+// bursts. Warnings are logged if the http.ResponseWriter does not implement
+// http.Flusher itself and the flusher of an unwrapped writer has to be used
+// instead, or if the request carries headers of a proxy, e.g. a Via header.
+// It is meant as a diagnostic aid during development.
+// Without this option, requests whose http.ResponseWriter does not implement
+// http.Flusher are rejected with 501 Not Implemented, as the unwrapped writer
+// is only used as a fallback if the buffering check is enabled.
+func WithBufferingCheck() Option {
+	return func(s *Streamer) {
+		s.bufferingCheck = true
+	}
+}
+
+// WithPlainTextErrors makes SendError send the plain error message instead of
+// a JSON object.
+func WithPlainTextErrors() Option {
+	return func(s *Streamer) {
+		s.plainErrors = true
+	}
+}
+
+// OverflowPolicy determines what happens with an event for a client whose
+// buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the broadcast until the client has space in its
+	// buffer. This is the default.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDrop drops the event for that client. Dropped events are
+	// counted, see Stats.
+	OverflowDrop
+)
+
+// WithOverflowPolicy sets the policy for events sent to clients whose buffer
+// is full.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(s *Streamer) {
+		s.overflow = policy
+	}
+}
+
+// WithDropOnFull sets whether events for clients whose buffer is full are
+// dropped, so that slow clients never block the broadcast, or whether the
+// broadcast blocks, which is the default. It is a shortcut for
+// WithOverflowPolicy with OverflowDrop or OverflowBlock.
+func WithDropOnFull(drop bool) Option {
+	if drop {
+		return WithOverflowPolicy(OverflowDrop)
+	}
+	return WithOverflowPolicy(OverflowBlock)
+}
+
+// FlushStrategy determines when events written to a client are flushed.
+// Flushing less often increases the throughput of high-rate streams at the
+// cost of latency.
+type FlushStrategy struct {
+	// Events is the number of written events after which the client is
+	// flushed. If Events is 0, the number of events is not limited.
+	Events int
+
+	// Interval is the maximum time an event remains unflushed.
+	// If Events is greater than 1 and Interval is 0, DefaultFlushInterval is
+	// used to bound the latency.
+	Interval time.Duration
+}
+
+// DefaultFlushInterval is the maximum time an event remains unflushed, if a
+// FlushStrategy limits only the number of events.
+const DefaultFlushInterval = 100 * time.Millisecond
+
+// WithFlushStrategy sets the strategy for flushing clients. By default,
+// clients are flushed after every event. Heartbeats and events sent with
+// SendEventNow are always flushed immediately.
+// A FlushStrategy without any limit flushes after every event.
+// Written but not yet flushed events are flushed before a connection is
+// closed cleanly by the server, e.g. by Close, DisconnectClient or a timeout.
+// If the client went away or a write failed, they are discarded, since they
+// can not be delivered anyway.
+func WithFlushStrategy(strategy FlushStrategy) Option {
+	return func(s *Streamer) {
+		s.flushEvents = strategy.Events
+		s.flushInterval = strategy.Interval
+		switch {
+		case strategy.Events <= 0 && strategy.Interval <= 0:
+			s.flushEvents, s.flushInterval = 1, 0
+		case strategy.Events > 1 && strategy.Interval <= 0:
+			s.flushInterval = DefaultFlushInterval
+		}
+	}
+}
+
+// WithAllowedOrigins restricts the origins which may subscribe to the stream.
+// Requests with an Origin header not matching any of the given origins are
+// rejected with http.StatusForbidden. Requests without an Origin header, which
+// are not cross-site requests from browsers, are allowed.
+// An origin is either matched exactly, e.g. "https://example.com", or may
+// contain a wildcard subdomain, e.g. "https://*.example.com", which matches
+// all subdomains but not the domain itself.
+func WithAllowedOrigins(origins []string) Option {
+	return func(s *Streamer) {
+		s.origins = origins
+	}
+}
+
+// originAllowed checks whether the given origin matches any of the allowed
+// origins.
+func originAllowed(allowed []string, origin string) bool {
+	for _, o := range allowed {
+		if i := strings.Index(o, "://*."); i >= 0 {
+			scheme, domain := o[:i+3], o[i+4:] // "https://", ".example.com"
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) &&
+				len(origin) > len(scheme)+len(domain) {
+				return true
+			}
+			continue
+		}
+		if origin == o {
+			return true
+		}
+	}
+	return false
+}
+
+// WithDirectBroadcast disables the run goroutine of the Streamer. Instead,
+// events are passed to the clients synchronously by the sending goroutine
+// while holding a lock. Send returns only after the event was passed to all
+// clients, which makes the delivery order and timing deterministic, e.g. for
+// tests or a single producer. With multiple concurrent producers, they contend
+// for the lock instead.
+func WithDirectBroadcast() Option {
+	return func(s *Streamer) {
+		s.direct = true
+	}
+}
+
+// WithEventQueueSize sets the size of the queue of sent events waiting to be
+// broadcast. The default is 1.
+// Sending an event blocks while the queue is full. A larger queue absorbs
+// bursts of events, decoupling producers from the pace of the broadcast, at
+// the cost of memory and the latency of queued events. It is distinct from the
+// per-client buffer size, see BufSize.
+// In direct broadcast mode, there is no queue.
+func WithEventQueueSize(n int) Option {
+	return func(s *Streamer) {
+		s.queueSize = n
+	}
+}
+
+// WithSkipWhenEmpty enables skipping sent events early, before they are
+// serialized, while no client is connected. This saves the work of formatting
+// and JSON encoding when nobody is listening.
+// Events which are retained or recorded for clients connecting later are not
+// skipped, see SendRetained, WithTopicRetention and WithHistory.
+func WithSkipWhenEmpty() Option {
+	return func(s *Streamer) {
+		s.skipEmpty = true
+	}
+}
+
+// WithInitialPadding enables writing a comment of 2 KiB to every client when
+// it connects. Some browsers and intermediaries buffer the first bytes of a
+// response before dispatching any events, which the padding defeats.
+func WithInitialPadding() Option {
+	return func(s *Streamer) {
+		s.padding = true
+	}
+}
+
+// WithMinChunk enables padding every write to a client to at least n bytes
+// with a trailing comment, e.g. for reverse proxies which buffer the response
+// until a minimum chunk size is reached. EventSource clients ignore the
+// comment. Writes of at least n bytes are not padded.
+// Clients which are not served Server-Sent Events are not padded, see
+// WithFraming.
+func WithMinChunk(n int) Option {
+	return func(s *Streamer) {
+		s.minChunk = n
+	}
+}
+
+// padChunk returns p padded with a comment to at least the minimum chunk
+// size, see WithMinChunk.
+func (s *Streamer) padChunk(p []byte) []byte {
+	pad := s.minChunk - len(p)
+	if pad <= 0 {
+		return p
+	}
+	spaces := pad - 1 - len(s.lineEnding) // ":{spaces}{le}"
+	if spaces < 0 {
+		spaces = 0
+	}
+	padded := make([]byte, len(p)+1+spaces, len(p)+1+spaces+len(s.lineEnding))
+	copy(padded, p)
+	padded[len(p)] = ':'
+	for i := len(p) + 1; i < len(padded); i++ {
+		padded[i] = ' '
+	}
+	return append(padded, s.lineEnding...)
+}
+
+// WithMaxConnectionAge limits the lifetime of client connections, e.g. to
+// periodically rebalance load or refresh authentication. When a connection
+// reaches the given age, the connection is closed, which makes the browser
+// reconnect. If a reconnection time is set, see WithRetry, it is sent again
+// before closing, so that the clients do not all reconnect at once.
+// A duration of 0 disables the limit.
+func WithMaxConnectionAge(d time.Duration) Option {
+	return func(s *Streamer) {
+		s.maxAge = d
+	}
+}
+
+// WithIdleTimeout enables disconnecting clients to which nothing, including
+// heartbeats, was written for the given duration. This prompts the client to
+// reconnect and protects against silently dead connections. Note that
+// heartbeats with a shorter interval keep connections from becoming idle.
+// A duration of 0 disables the timeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Streamer) {
+		s.idleTimeout = d
+	}
+}
+
+// WithReadDeadline enables periodically extending the read deadline of client
+// connections to the given duration from now, using http.ResponseController.
+// If setting the deadline fails, the connection is considered dead and the
+// client is disconnected. This supplements the detection of closed
+// connections via the request context, e.g. for half-open TCP connections.
+// ResponseWriters which do not support deadlines are not affected.
+// This requires Go 1.20 or newer, on older versions it has no effect.
+func WithReadDeadline(d time.Duration) Option {
+	return func(s *Streamer) {
+		s.readDeadline = d
+	}
+}
+
+// ClientProfile adapts the stream to the capabilities of a single client.
+type ClientProfile struct {
+	// Padding enables writing an initial padding comment, see
+	// WithInitialPadding.
+	Padding bool
+
+	// Heartbeat is the heartbeat interval, see WithHeartbeat.
+	// 0 disables heartbeats.
+	Heartbeat time.Duration
+
+	// Retry is the reconnection time sent to the client when it connects,
+	// see WithRetry. 0 sends no reconnection time, the client uses its
+	// default.
+	Retry time.Duration
+}
+
+// WithClientProfiler sets a function which computes the profile of each
+// connecting client from its request, e.g. from the User-Agent header to
+// adapt the stream to quirks of older browsers and polyfills.
+// The profile is computed once when the client connects. It replaces the
+// settings of WithInitialPadding, WithHeartbeat and WithHTTP2Heartbeat.
+func WithClientProfiler(profiler func(r *http.Request) ClientProfile) Option {
+	return func(s *Streamer) {
+		s.profiler = profiler
+	}
+}
+
+// WithHeartbeat enables heartbeats: an empty comment is sent to every client
+// when the given interval elapsed, which keeps idle connections from being
+// closed by proxies. An interval of 0 disables heartbeats.
+func WithHeartbeat(interval time.Duration) Option {
+	return func(s *Streamer) {
+		s.heartbeat = interval
+	}
+}
+
+// WithHTTP2Heartbeat sets the heartbeat interval for HTTP/2 connections,
+// overriding the interval set by WithHeartbeat. An interval of 0 disables
+// comment heartbeats for HTTP/2 connections.
+// HTTP/2 has its own protocol-level PING frames, which handlers can not send
+// directly. Instead, they are sent by the server itself if configured, e.g.
+// via the ReadIdleTimeout of golang.org/x/net/http2.Server. If so, comment
+// heartbeats are redundant for HTTP/2 connections.
+func WithHTTP2Heartbeat(interval time.Duration) Option {
+	return func(s *Streamer) {
+		s.heartbeatHTTP2 = interval
+		s.heartbeatHTTP2Set = true
+	}
+}
+
+// WithIdleHeartbeat makes heartbeats only be sent to clients to which nothing
+// was written for the heartbeat interval, instead of every interval. Active
+// streams, which are kept alive by their events anyway, then receive no
+// heartbeats, which saves bandwidth.
+func WithIdleHeartbeat() Option {
+	return func(s *Streamer) {
+		s.heartbeatIdle = true
+	}
+}
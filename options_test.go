@@ -0,0 +1,1177 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuthorizer(t *testing.T) {
+	streamer := MustNew(WithAuthorizer(func(r *http.Request) (bool, int) {
+		switch r.Header.Get("Authorization") {
+		case "valid":
+			return true, 0
+		case "expired":
+			return false, http.StatusUnauthorized
+		default:
+			return false, 0
+		}
+	}))
+
+	var tests = []struct {
+		auth   string
+		status int
+		body   string
+	}{
+		{"valid", http.StatusOK, ""},
+		{"expired", http.StatusUnauthorized, "Unauthorized\n"},
+		{"", http.StatusForbidden, "Forbidden\n"},
+	}
+
+	for _, test := range tests {
+		w := NewMockResponseWriteFlushCloser()
+		r, cancel := NewMockRequestWithTimeout(100 * time.Millisecond)
+		r.Header.Set("Authorization", test.auth)
+
+		streamer.ServeHTTP(w, r)
+		cancel()
+
+		if w.status != test.status {
+			t.Errorf("wrong status code for %q: %d", test.auth, w.status)
+		}
+		if w.written != test.body {
+			t.Errorf("wrong body for %q, got: %q", test.auth, w.written)
+		}
+		if test.status == http.StatusOK && w.Header().Get("Content-Type") != "text/event-stream" {
+			t.Errorf("authorized request did not receive a stream")
+		}
+	}
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	streamer := MustNew(WithAllowedOrigins([]string{
+		"https://example.com",
+		"https://*.example.org",
+	}))
+
+	var tests = []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusOK},
+		{"https://example.com", http.StatusOK},
+		{"https://sub.example.org", http.StatusOK},
+		{"https://a.b.example.org", http.StatusOK},
+		{"https://example.org", http.StatusForbidden},
+		{"http://sub.example.org", http.StatusForbidden},
+		{"https://evilexample.org", http.StatusForbidden},
+		{"https://example.com.evil.com", http.StatusForbidden},
+		{"http://example.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		w := NewMockResponseWriteFlushCloser()
+		r, cancel := NewMockRequestWithTimeout(10 * time.Millisecond)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+
+		streamer.ServeHTTP(w, r)
+		cancel()
+
+		if w.status != test.status {
+			t.Errorf("wrong status code for origin %q: %d", test.origin, w.status)
+		}
+	}
+}
+
+func TestDirectBroadcast(t *testing.T) {
+	streamer := MustNew(WithDirectBroadcast())
+	streamer.BufSize(10)
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+
+	// Sending returns after the event was passed to the client
+	streamer.SendString("", "", "1")
+	streamer.SendInt("", "", 2)
+	streamer.SendEvent(Event{Event: "msg", Data: []byte("3")})
+
+	var expected = []string{
+		"data:1\n\n",
+		"data:2\n\n",
+		"event:msg\ndata:3\n\n",
+	}
+	for _, e := range expected {
+		if got := recv(t, w.writes); got != e {
+			t.Errorf("wrong event, expected: %q, got: %q", e, got)
+		}
+	}
+
+	stop()
+	if n := streamer.Stats().Clients; n != 0 {
+		t.Fatal("expected 0 clients, has:", n)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	streamer := MustNew(
+		WithHeartbeat(10*time.Millisecond),
+		WithHTTP2Heartbeat(0),
+	)
+
+	// HTTP/1.1
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	for i := 0; i < 2; i++ {
+		if got := recv(t, w.writes); got != ":\n\n" {
+			t.Errorf("expected heartbeat comment, got: %q", got)
+		}
+	}
+	stop()
+
+	// HTTP/2
+	w = NewMockChanWriteFlusher()
+	r, cancel = NewMockRequest()
+	r.ProtoMajor, r.ProtoMinor = 2, 0
+	stop = serve(t, streamer, w, r, cancel)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	if len(w.writes) != 0 {
+		t.Errorf("expected no heartbeats for HTTP/2, got: %d", len(w.writes))
+	}
+}
+
+func TestIdleHeartbeat(t *testing.T) {
+	streamer := MustNew(
+		WithHeartbeat(50*time.Millisecond),
+		WithIdleHeartbeat(),
+		WithGroupKey(func(r *http.Request) string {
+			return r.Header.Get("X-Group")
+		}),
+	)
+
+	active := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	r.Header.Set("X-Group", "active")
+	defer serve(t, streamer, active, r, cancel)()
+
+	idle := NewMockChanWriteFlusher()
+	r, cancel = NewMockRequest()
+	defer serve(t, streamer, idle, r, cancel)()
+
+	// events are sent more often than the heartbeat interval
+	for i := 0; i < 15; i++ {
+		streamer.SendToGroup("active", Event{Data: []byte("x")})
+		time.Sleep(5 * time.Millisecond)
+	}
+	for i := 0; i < 15; i++ {
+		if got := recv(t, active.writes); got != "data:x\n\n" {
+			t.Errorf("expected only events for the active client, got: %q", got)
+		}
+	}
+	if len(idle.writes) == 0 {
+		t.Error("expected heartbeats for the idle client")
+	}
+	for len(idle.writes) > 0 {
+		if got := <-idle.writes; got != ":\n\n" {
+			t.Errorf("expected heartbeat comment, got: %q", got)
+		}
+	}
+
+	// the active client receives heartbeats once it is idle
+	if got := recv(t, active.writes); got != ":\n\n" {
+		t.Errorf("expected heartbeat comment, got: %q", got)
+	}
+}
+
+func TestMinChunk(t *testing.T) {
+	streamer := MustNew(WithMinChunk(64))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	large := strings.Repeat("x", 64)
+	streamer.SendString("1", "small", "x")
+	streamer.SendString("", "", large)
+
+	got := recv(t, w.writes)
+	if event := "id:1\nevent:small\ndata:x\n\n"; len(got) != 64 || !strings.HasPrefix(got, event) ||
+		strings.TrimSpace(got[len(event):]) != ":" || !strings.HasSuffix(got, "\n") {
+		t.Errorf("expected the small event padded with a comment to 64 bytes, got: %q", got)
+	}
+	e, err := NewDecoder(strings.NewReader(got)).Decode()
+	if err != nil || e.ID != "1" || e.Event != "small" || string(e.Data) != "x" {
+		t.Errorf("padded event decoded as %+v, %v", e, err)
+	}
+
+	if got := recv(t, w.writes); got != "data:"+large+"\n\n" {
+		t.Errorf("expected the large event untouched, got: %q", got)
+	}
+}
+
+func TestInitialPadding(t *testing.T) {
+	streamer := MustNew(WithInitialPadding())
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.SendString("", "", "first")
+	streamer.SendString("", "", "second")
+
+	padding := recv(t, w.writes)
+	if len(padding) != 2048 {
+		t.Errorf("expected 2048 bytes of padding, got: %d", len(padding))
+	}
+	if padding[0] != ':' || strings.Trim(padding[1:], " ") != "\n" {
+		t.Errorf("padding is not a comment: %q", padding)
+	}
+
+	if got := recv(t, w.writes); got != "data:first\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+	if got := recv(t, w.writes); got != "data:second\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+}
+
+func TestMaxConnectionAge(t *testing.T) {
+	var tests = []struct {
+		opts     []Option
+		expected []string
+	}{
+		{nil, nil},
+		// the configured reconnection time is sent again before closing
+		{[]Option{WithRetry(3 * time.Second)}, []string{"retry:3000\n\n", "retry:3000\n\n"}},
+	}
+	for _, test := range tests {
+		streamer := MustNew(append(test.opts, WithMaxConnectionAge(50*time.Millisecond))...)
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+
+		done := make(chan struct{})
+		go func() {
+			streamer.ServeHTTP(w, r)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("handler did not return after max connection age")
+		}
+		cancel()
+
+		for _, expected := range test.expected {
+			if got := recv(t, w.writes); got != expected {
+				t.Errorf("expected %q, got: %q", expected, got)
+			}
+		}
+		select {
+		case got := <-w.writes:
+			t.Errorf("unexpected write: %q", got)
+		default:
+		}
+		if n := streamer.Stats().Clients; n != 0 {
+			t.Error("expected 0 clients, has:", n)
+		}
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	var streamer *Streamer
+	streamer = MustNew(WithBacklog(func(r *http.Request) []Event {
+		// events sent while the backlog is fetched are not missed
+		go streamer.SendString("", "", "live")
+		time.Sleep(10 * time.Millisecond)
+
+		return []Event{
+			{ID: "1", Data: []byte("history 1")},
+			{ID: "2", Data: []byte("history 2")},
+		}
+	}))
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	var expected = []string{
+		"id:1\ndata:history 1\n\nid:2\ndata:history 2\n\n",
+		"data:live\n\n",
+	}
+	for _, e := range expected {
+		if got := recv(t, w.writes); got != e {
+			t.Errorf("wrong event, expected: %q, got: %q", e, got)
+		}
+	}
+}
+
+func TestConnectPreamble(t *testing.T) {
+	streamer := MustNew(
+		WithInitialPadding(),
+		WithRetry(3*time.Second),
+		WithConnectPreamble([]Event{
+			{Event: "hello", Data: []byte("1")},
+			{Data: []byte("2")},
+		}),
+		WithBacklog(func(r *http.Request) []Event {
+			return []Event{{ID: "b", Data: []byte("backlog")}}
+		}),
+	)
+	streamer.SendRetained("", "state", []byte("retained"))
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	expected := strings.Join([]string{
+		string(paddingComment),
+		"retry:3000\n\n",
+		"event:hello\ndata:1\n\n",
+		"data:2\n\n",
+		"event:state\ndata:retained\n\n",
+		"id:b\ndata:backlog\n\n",
+	}, "")
+	if got := recv(t, w.writes); got != expected {
+		t.Errorf("wrong initial events, expected: %q, got: %q", expected, got)
+	}
+
+	// the preamble is only written to the connecting client
+	r, cancel = NewMockRequest()
+	defer serve(t, streamer, NewMockChanWriteFlusher(), r, cancel)()
+	streamer.SendString("", "", "live")
+	if got := recv(t, w.writes); got != "data:live\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+}
+
+func TestConnectSingleFlush(t *testing.T) {
+	streamer := MustNew(
+		WithInitialPadding(),
+		WithRetry(3*time.Second),
+		WithConnectPreamble([]Event{
+			{Event: "hello", Data: []byte("1")},
+			{Data: []byte("2")},
+		}),
+	)
+
+	w := NewMockFlushRecorder()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.SendString("", "", "live")
+	var expected = []string{
+		string(paddingComment) + "retry:3000\n\nevent:hello\ndata:1\n\ndata:2\n\n",
+		flushMarker,
+		"data:live\n\n",
+		flushMarker,
+	}
+	for _, e := range expected {
+		if got := recv(t, w.writes); got != e {
+			t.Errorf("wrong write, expected: %q, got: %q", e, got)
+		}
+	}
+}
+
+func TestEventPrefix(t *testing.T) {
+	streamer := MustNew(
+		WithEventPrefix("tenant."),
+		WithBacklog(func(r *http.Request) []Event {
+			return []Event{{Event: "history", Data: []byte("h")}}
+		}),
+	)
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.SendString("", "update", "s")
+	streamer.SendInt("1", "count", 42)
+	streamer.SendEvent(Event{Event: "event", Data: []byte("e")})
+	streamer.SendString("", "", "untyped")
+
+	var expected = []string{
+		"event:tenant.history\ndata:h\n\n",
+		"event:tenant.update\ndata:s\n\n",
+		"id:1\nevent:tenant.count\ndata:42\n\n",
+		"event:tenant.event\ndata:e\n\n",
+		"data:untyped\n\n",
+	}
+	for _, e := range expected {
+		if got := recv(t, w.writes); got != e {
+			t.Errorf("wrong event, expected: %q, got: %q", e, got)
+		}
+	}
+}
+
+type orderCreated struct {
+	ID int `json:"id"`
+}
+
+type orderShipped struct {
+	ID int `json:"id"`
+}
+
+func TestAutoEventType(t *testing.T) {
+	streamer := MustNew(WithAutoEventType(TypeName))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	var tests = []struct {
+		event    string
+		v        interface{}
+		expected string
+	}{
+		{"", orderCreated{1}, "event:orderCreated\ndata:{\"id\":1}\n\n"},
+		{"", &orderShipped{1}, "event:orderShipped\ndata:{\"id\":1}\n\n"},
+		{"explicit", orderCreated{2}, "event:explicit\ndata:{\"id\":2}\n\n"},
+		{"", "unnamed", "event:string\ndata:unnamed\n\n"},
+		{"", map[string]int{"a": 1}, "data:{\"a\":1}\n\n"},
+		{"", nil, "data:null\n\n"},
+	}
+	for _, test := range tests {
+		if err := streamer.Send("", test.event, test.v); err != nil {
+			t.Errorf("%#v: unexpected error: %v", test.v, err)
+		}
+		if got := recv(t, w.writes); got != test.expected {
+			t.Errorf("%#v: expected %q, got: %q", test.v, test.expected, got)
+		}
+	}
+}
+
+func TestSendObserver(t *testing.T) {
+	type observed struct {
+		event   string
+		data    string
+		clients int
+	}
+	var got []observed
+	streamer := MustNew(
+		WithDirectBroadcast(),
+		WithTopicParam("topic"),
+		WithSendObserver(func(e Event, clients int) {
+			got = append(got, observed{e.Event, string(e.Data), clients})
+		}),
+	)
+	for _, query := range []string{"topic=news", "topic=news", ""} {
+		r, cancel := NewMockRequest()
+		r.URL.RawQuery = query
+		defer serve(t, streamer, NewMockChanWriteFlusher(), r, cancel)()
+	}
+
+	streamer.SendString("", "all", "a")
+	streamer.SendStringTo("news", "", "news", "n")
+	streamer.SendBatch(Event{Event: "b1", Data: []byte("1")}, Event{Event: "b2", Data: []byte("2")})
+	if err := streamer.SetRetry(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []observed{
+		{"all", "a", 3},
+		{"news", "n", 2},
+		{"b1", "1", 3},
+		{"b2", "2", 3},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got: %v", expected, got)
+	}
+}
+
+func TestRoutingComment(t *testing.T) {
+	streamer := MustNew(
+		WithRoutingComment(func(e Event) string {
+			if e.Event == "" {
+				return ""
+			}
+			return "route=" + string(e.Data) + "\nid:injected"
+		}),
+		WithBacklog(func(r *http.Request) []Event {
+			return []Event{{Event: "history", Data: []byte("h")}}
+		}),
+	)
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	sent := streamer.SendEvent(Event{ID: "1", Event: "update", Data: []byte("panel")})
+	streamer.SendString("", "", "untyped")
+	streamer.SendBatch(Event{Event: "a", Data: []byte("x")}, Event{Data: []byte("y")})
+
+	var expected = []string{
+		":route=h\nevent:history\ndata:h\n\n",
+		":route=panel\nid:1\nevent:update\ndata:panel\n\n",
+		"data:untyped\n\n",
+		":route=x\nevent:a\ndata:x\n\ndata:y\n\n",
+	}
+	for _, e := range expected {
+		if got := recv(t, w.writes); got != e {
+			t.Errorf("wrong event, expected: %q, got: %q", e, got)
+		}
+	}
+	if string(sent) != expected[1] {
+		t.Errorf("expected the sent event with the comment, got: %q", sent)
+	}
+
+	// the comment is ignored by clients
+	d := NewDecoder(strings.NewReader(expected[1]))
+	e, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != "1" || e.Event != "update" || string(e.Data) != "panel" {
+		t.Errorf("unexpected decoded event: %+v", e)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for _, contentType := range []string{
+		"text/event-stream; charset=utf-8",
+		"Text/Event-Stream",
+	} {
+		streamer := MustNew(WithContentType(contentType))
+		w := NewMockResponseWriteFlushCloser()
+		r, cancel := NewMockRequestWithTimeout(10 * time.Millisecond)
+
+		streamer.ServeHTTP(w, r)
+		cancel()
+
+		if got := w.Header().Get("Content-Type"); got != contentType {
+			t.Errorf("expected %q, got %q", contentType, got)
+		}
+	}
+
+	for _, contentType := range []string{
+		"application/json",
+		"text/event-stream; charset",
+	} {
+		if _, err := New(WithContentType(contentType)); err == nil {
+			t.Errorf("no error for %q", contentType)
+		}
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	streamer := MustNew(WithIdleTimeout(50 * time.Millisecond))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(w, r)
+		close(done)
+	}()
+	waitForClients(t, streamer, 1)
+
+	// events keep the connection from becoming idle
+	for i := 0; i < 5; i++ {
+		streamer.SendString("", "", "active")
+		recv(t, w.writes)
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("active client was disconnected")
+	default:
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not return after the idle timeout")
+	}
+	waitForClients(t, streamer, 0)
+}
+
+func TestClientProfiler(t *testing.T) {
+	streamer := MustNew(
+		WithInitialPadding(),
+		WithClientProfiler(func(r *http.Request) ClientProfile {
+			if strings.Contains(r.UserAgent(), "OldBrowser") {
+				return ClientProfile{Padding: true, Retry: 5 * time.Second}
+			}
+			return ClientProfile{}
+		}),
+	)
+
+	// old browser
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	r.Header.Set("User-Agent", "OldBrowser/1.0")
+	stop := serve(t, streamer, w, r, cancel)
+	streamer.SendString("", "", "event")
+	if got := recv(t, w.writes); got != string(paddingComment)+"retry:5000\n\n" {
+		t.Errorf("expected padding and retry, got: %q", got)
+	}
+	if got := recv(t, w.writes); got != "data:event\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+	stop()
+
+	// modern browser
+	w = NewMockChanWriteFlusher()
+	r, cancel = NewMockRequest()
+	r.Header.Set("User-Agent", "NewBrowser/99.0")
+	stop = serve(t, streamer, w, r, cancel)
+	streamer.SendString("", "", "event")
+	if got := recv(t, w.writes); got != "data:event\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+	stop()
+}
+
+func TestSkipWhenEmpty(t *testing.T) {
+	var marshaled int
+	streamer := MustNew(
+		WithSkipWhenEmpty(),
+		WithJSONMarshaler(func(v interface{}) ([]byte, error) {
+			marshaled++
+			return json.Marshal(v)
+		}),
+	)
+
+	if err := streamer.SendJSON("", "", map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if marshaled != 0 {
+		t.Fatal("marshaler called without connected clients")
+	}
+	streamer.SendRetained("", "state", []byte("retained"))
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	if err := streamer.SendJSON("", "", map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if marshaled != 1 {
+		t.Fatal("marshaler not called with a connected client")
+	}
+
+	if got := recv(t, w.writes); got != "event:state\ndata:retained\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+	if got := recv(t, w.writes); got != "data:{\"a\":1}\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+}
+
+func TestSkipWhenEmptyRecorded(t *testing.T) {
+	streamer := MustNew(WithSkipWhenEmpty(), WithHistory(10), WithTopicParam("topic"), WithTopicRetention())
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	streamer.SendString("1", "", "first")
+	recv(t, w.writes)
+	stop()
+
+	// events sent without clients are recorded for later clients
+	streamer.SendString("2", "", "missed")
+	streamer.SendStringTo("news", "", "", "state")
+
+	w = NewMockChanWriteFlusher()
+	r, cancel = NewMockRequest()
+	r.Header.Set("Last-Event-ID", "1")
+	r.URL.RawQuery = "topic=news"
+	defer serve(t, streamer, w, r, cancel)()
+	if got := recv(t, w.writes); got != "data:state\n\nid:2\ndata:missed\n\n" {
+		t.Errorf("expected the retained and the missed event, got: %q", got)
+	}
+}
+
+func BenchmarkSendJSONNoClients(b *testing.B) {
+	benchmarkSendJSON(b, MustNew())
+}
+
+func BenchmarkSendJSONNoClientsSkip(b *testing.B) {
+	benchmarkSendJSON(b, MustNew(WithSkipWhenEmpty()))
+}
+
+func benchmarkSendJSON(b *testing.B, streamer *Streamer) {
+	v := map[string]interface{}{"symbol": "GO", "price": 1.5, "tags": []string{"a", "b"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		streamer.SendJSON("", "price", v)
+	}
+}
+
+type mockObserver struct {
+	mu           sync.Mutex
+	connected    int
+	disconnected int
+	broadcasts   []int
+}
+
+func (o *mockObserver) Connect(r *http.Request) func() {
+	o.mu.Lock()
+	o.connected++
+	o.mu.Unlock()
+	return func() {
+		o.mu.Lock()
+		o.disconnected++
+		o.mu.Unlock()
+	}
+}
+
+func (o *mockObserver) Broadcast(clients int) {
+	o.mu.Lock()
+	o.broadcasts = append(o.broadcasts, clients)
+	o.mu.Unlock()
+}
+
+func TestObserver(t *testing.T) {
+	observer := new(mockObserver)
+	streamer := MustNew(WithObserver(observer), WithGroupKey(func(r *http.Request) string {
+		return r.Header.Get("X-Group")
+	}), WithClientID(func(r *http.Request) string {
+		return r.Header.Get("X-Group")
+	}))
+
+	streamer.SendString("", "", "nobody")
+
+	w1 := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	r.Header.Set("X-Group", "a")
+	stop1 := serve(t, streamer, w1, r, cancel)
+
+	w2 := NewMockChanWriteFlusher()
+	r, cancel = NewMockRequest()
+	stop2 := serve(t, streamer, w2, r, cancel)
+
+	streamer.SendString("", "", "all")
+	streamer.SendToGroup("a", Event{Data: []byte("group")})
+	streamer.SendStringExcept("a", "", "", "except")
+	streamer.Ping()
+	streamer.SetRetry(time.Second)
+	recv(t, w1.writes)
+	recv(t, w1.writes)
+	for i := 0; i < 4; i++ {
+		recv(t, w2.writes)
+	}
+
+	stop1()
+	stop2()
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	if observer.connected != 2 || observer.disconnected != 2 {
+		t.Errorf("expected 2 connects and disconnects, got: %d, %d", observer.connected, observer.disconnected)
+	}
+	if fmt.Sprint(observer.broadcasts) != "[0 2 1 1]" {
+		t.Errorf("wrong broadcast client counts: %v", observer.broadcasts)
+	}
+}
+
+func TestEventQueueSize(t *testing.T) {
+	const n = 100
+
+	streamer := MustNew(WithEventQueueSize(n))
+	w := NewMockBlockingWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	// block the broadcast on the client with a full buffer
+	streamer.SendString("", "", "blocked")
+	<-w.writing
+	streamer.SendString("", "", "buffered")
+	streamer.SendString("", "", "buffered")
+
+	// a burst of n events does not block while the queue absorbs it
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			streamer.SendString("", "", "burst")
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		t.Fatal("sending the burst blocked")
+	}
+	close(w.unblock)
+}
+
+func BenchmarkBroadcastQueue128(b *testing.B) {
+	benchmarkBroadcast(b, WithEventQueueSize(128))
+}
+
+func TestLineEnding(t *testing.T) {
+	golden := []string{
+		"id:1\nevent:msg\ndata:a\ndata:b\n\n",
+		"data:bytes\ndata:\n\n",
+		"data:a\ndata:b\n\n",
+		"data:-42\n\n",
+		"data:42\n\n",
+		"data:{\"a\":1}\n\n",
+		"data\n\n",
+	}
+	send := func(s *Streamer) {
+		s.SendString("1", "msg", "a\nb")
+		s.SendBytes("", "", []byte("bytes\n"))
+		s.SendLines("", "", [][]byte{[]byte("a"), []byte("b")})
+		s.SendInt("", "", -42)
+		s.SendUint("", "", 42)
+		s.SendJSON("", "", map[string]int{"a": 1})
+		s.SendString("", "", "")
+	}
+
+	for _, lineEnding := range []string{"\n", "\r\n"} {
+		streamer := MustNew(WithLineEnding(lineEnding), WithClientProfiler(func(r *http.Request) ClientProfile {
+			return ClientProfile{Retry: time.Second}
+		}))
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		stop := serve(t, streamer, w, r, cancel)
+
+		if got, expected := recv(t, w.writes), "retry:1000"+lineEnding+lineEnding; got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+		send(streamer)
+		for _, event := range golden {
+			expected := strings.Replace(event, "\n", lineEnding, -1)
+			if got := recv(t, w.writes); got != expected {
+				t.Errorf("expected %q, got: %q", expected, got)
+			}
+		}
+		stop()
+	}
+
+	if _, err := New(WithLineEnding("\r")); err == nil {
+		t.Error("no error for an invalid line ending")
+	}
+}
+
+func TestFlushStrategy(t *testing.T) {
+	const e = "data:x\n\n"
+	var tests = []struct {
+		strategy FlushStrategy
+		send     func(s *Streamer)
+		expected []string
+	}{
+		{FlushStrategy{}, func(s *Streamer) {
+			s.SendString("", "", "x")
+			s.SendString("", "", "x")
+		}, []string{e, flushMarker, e, flushMarker}},
+		{FlushStrategy{Events: 3, Interval: time.Hour}, func(s *Streamer) {
+			for i := 0; i < 6; i++ {
+				s.SendString("", "", "x")
+			}
+		}, []string{e, e, e, flushMarker, e, e, e, flushMarker}},
+		{FlushStrategy{Events: 3, Interval: time.Hour}, func(s *Streamer) {
+			s.SendString("", "", "x")
+			s.SendEventNow(Event{Data: []byte("x")})
+		}, []string{e, e, flushMarker}},
+		{FlushStrategy{Interval: 200 * time.Millisecond}, func(s *Streamer) {
+			for i := 0; i < 3; i++ {
+				s.SendString("", "", "x")
+			}
+		}, []string{e, e, e, flushMarker}},
+	}
+
+	for _, test := range tests {
+		streamer := MustNew(WithFlushStrategy(test.strategy))
+		w := NewMockFlushRecorder()
+		r, cancel := NewMockRequest()
+		stop := serve(t, streamer, w, r, cancel)
+
+		test.send(streamer)
+		for i, expected := range test.expected {
+			if got := recv(t, w.writes); got != expected {
+				t.Errorf("%+v: write %d: expected %q, got: %q", test.strategy, i, expected, got)
+			}
+		}
+		stop()
+		if len(w.writes) > 0 {
+			t.Errorf("%+v: unexpected write: %q", test.strategy, <-w.writes)
+		}
+	}
+}
+
+// mockFailingFlushRecorder records writes and flushes like mockFlushRecorder,
+// but fails all writes after the first n.
+type mockFailingFlushRecorder struct {
+	mockFlushRecorder
+	n *int
+}
+
+func (m mockFailingFlushRecorder) Write(p []byte) (int, error) {
+	if *m.n == 0 {
+		return 0, errors.New("broken pipe")
+	}
+	*m.n--
+	return m.mockFlushRecorder.Write(p)
+}
+
+func TestFlushOnClose(t *testing.T) {
+	const e = "data:x\n\n"
+	strategy := WithFlushStrategy(FlushStrategy{Events: 10, Interval: time.Hour})
+
+	// pending events are flushed when the server closes the connection
+	streamer := MustNew(strategy)
+	w := NewMockFlushRecorder()
+	r, cancel := NewMockRequest()
+	defer cancel()
+	go streamer.ServeHTTP(w, r)
+	waitForClients(t, streamer, 1)
+	streamer.SendString("", "", "x")
+	streamer.SendString("", "", "x")
+	streamer.Close()
+	for _, expected := range []string{e, e, flushMarker} {
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+
+	// but not after a write failed
+	streamer = MustNew(strategy)
+	n := 1
+	failing := mockFailingFlushRecorder{NewMockFlushRecorder(), &n}
+	r, cancel = NewMockRequest()
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(failing, r)
+		close(done)
+	}()
+	waitForClients(t, streamer, 1)
+	streamer.SendString("", "", "x")
+	streamer.SendString("", "", "x")
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not return after the write error")
+	}
+	if got := recv(t, failing.writes); got != e {
+		t.Errorf("expected %q, got: %q", e, got)
+	}
+	if len(failing.writes) > 0 {
+		t.Errorf("unexpected write or flush: %q", <-failing.writes)
+	}
+}
+
+func TestMaxConnectionsPerClient(t *testing.T) {
+	streamer := MustNew(WithMaxConnectionsPerClient(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}, 2))
+
+	connect := func(user string) (stop func()) {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-User", user)
+		return serve(t, streamer, w, r, cancel)
+	}
+	reject := func(user string) {
+		w := NewMockResponseWriteFlushCloser()
+		r, cancel := NewMockRequestWithTimeout(time.Second)
+		defer cancel()
+		r.Header.Set("X-User", user)
+		streamer.ServeHTTP(w, r)
+		if w.status != http.StatusTooManyRequests {
+			t.Errorf("%q: expected status %d, got: %d", user, http.StatusTooManyRequests, w.status)
+		}
+	}
+
+	stopA := connect("a")
+	defer connect("a")()
+	reject("a")
+
+	// other keys and clients without a key are not affected
+	defer connect("b")()
+	defer connect("")()
+	defer connect("")()
+	defer connect("")()
+
+	// closed connections free their slot
+	stopA()
+	waitForClients(t, streamer, 5)
+	defer connect("a")()
+	reject("a")
+}
+
+func TestOnClientGone(t *testing.T) {
+	type call struct {
+		id    string
+		cause GoneCause
+	}
+	calls := make(chan call, 10)
+	newStreamer := func() *Streamer {
+		return MustNew(
+			WithClientID(func(r *http.Request) string { return r.Header.Get("X-Client") }),
+			WithOnClientGone(func(clientID string, r *http.Request, cause GoneCause) {
+				calls <- call{clientID, cause}
+			}),
+		)
+	}
+	expect := func(expected call) {
+		select {
+		case c := <-calls:
+			if c != expected {
+				t.Errorf("expected %v, got: %v", expected, c)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("callback was not called")
+		}
+	}
+
+	// the request context is canceled
+	streamer := newStreamer()
+	r, cancel := NewMockRequest()
+	r.Header.Set("X-Client", "a")
+	serve(t, streamer, NewMockChanWriteFlusher(), r, cancel)()
+	expect(call{"a", GoneCanceled})
+
+	// writing fails
+	r, cancel = NewMockRequest()
+	defer cancel()
+	r.Header.Set("X-Client", "b")
+	stop := serve(t, streamer, mockWriteErrorFlusher{NewMockResponseWriter()}, r, cancel)
+	streamer.SendString("", "", "event")
+	expect(call{"b", GoneWriteError})
+	stop()
+
+	// connections ended by the server are not reported
+	r, cancel = NewMockRequest()
+	defer cancel()
+	r.Header.Set("X-Client", "c")
+	go streamer.ServeHTTP(NewMockChanWriteFlusher(), r)
+	waitForClients(t, streamer, 1)
+	streamer.Close()
+	select {
+	case c := <-calls:
+		t.Errorf("unexpected call: %v", c)
+	default:
+	}
+}
+
+func TestHTTP10(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		var opts []Option
+		if allow {
+			opts = append(opts, WithHTTP10())
+		}
+		streamer := MustNew(opts...)
+		w := NewMockResponseWriteFlushCloser()
+		r, cancel := NewMockRequestWithTimeout(50 * time.Millisecond)
+		r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/1.0", 1, 0
+
+		streamer.ServeHTTP(w, r)
+		cancel()
+
+		status, body := http.StatusHTTPVersionNotSupported, "HTTP/1.0 not supported\n"
+		if allow {
+			status, body = http.StatusOK, ""
+		}
+		if w.status != status {
+			t.Errorf("allow %v: expected status %d, got: %d", allow, status, w.status)
+		}
+		if w.written != body {
+			t.Errorf("allow %v: expected body %q, got: %q", allow, body, w.written)
+		}
+	}
+}
+
+func TestDropOnFull(t *testing.T) {
+	for _, drop := range []bool{true, false} {
+		streamer := MustNew(WithDropOnFull(drop))
+		streamer.BufSize(1)
+		w := NewMockBlockingWriteFlusher()
+		r, cancel := NewMockRequest()
+		stop := serve(t, streamer, w, r, cancel)
+
+		// the client is stuck writing the first event
+		streamer.SendString("", "", "blocked")
+		<-w.writing
+
+		sent := make(chan struct{})
+		go func() {
+			for i := 0; i < 5; i++ {
+				streamer.SendString("", "", "event")
+			}
+			close(sent)
+		}()
+		select {
+		case <-sent:
+			if !drop {
+				t.Error("broadcast did not block on the full buffer")
+			}
+		case <-time.After(100 * time.Millisecond):
+			if drop {
+				t.Error("broadcast blocked on the full buffer")
+			}
+		}
+
+		close(w.unblock)
+		<-sent
+		if dropped := streamer.Stats().Dropped; (dropped > 0) != drop {
+			t.Errorf("drop %v: unexpected number of dropped events: %d", drop, dropped)
+		}
+		stop()
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	key := func(r *http.Request) string { return r.RemoteAddr }
+	var tests = []struct {
+		opts []Option
+		err  string
+	}{
+		{[]Option{WithTopicRetention()}, "sse: WithTopicRetention requires WithTopicParam"},
+		{[]Option{WithMaxConnectionsPerClient(nil, 1)}, "sse: WithMaxConnectionsPerClient requires a key function and a limit of at least 1"},
+		{[]Option{WithMaxConnectionsPerClient(key, -1)}, "sse: WithMaxConnectionsPerClient requires a key function and a limit of at least 1"},
+		{[]Option{WithTraceID("", "X-Trace-Id")}, "sse: WithTraceID requires a header to echo the trace ID"},
+		{[]Option{WithEventQueueSize(-1)}, "sse: the event queue size must not be negative"},
+		{[]Option{WithDedup(0)}, "sse: the deduplication window must be positive"},
+		{[]Option{WithMaxLineLength(-1, LineSplit)}, "sse: the maximum line length must not be negative"},
+		{[]Option{WithReconnectGrace(time.Second)}, "sse: WithReconnectGrace requires WithClientID"},
+		{[]Option{WithLineEnding("\r"), WithRetry(0)}, `sse: invalid line ending "\r"`},
+	}
+	for _, test := range tests {
+		streamer, err := New(test.opts...)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("expected error %q, got: %v", test.err, err)
+		}
+		if streamer != nil {
+			t.Error("expected no Streamer for invalid options")
+		}
+	}
+
+	streamer, err := New(WithTopicParam("topic"), WithTopicRetention(), WithMaxConnectionsPerClient(key, 1))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	streamer.Close()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for invalid options")
+		}
+	}()
+	MustNew(WithTopicRetention())
+}
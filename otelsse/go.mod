@@ -0,0 +1,26 @@
+module github.com/julienschmidt/sse/otelsse
+
+go 1.25.0
+
+// The sse module is developed in the same repository. When releasing, tag the
+// sse module first and require the tagged version here; the replace directive
+// only applies within this repository.
+replace github.com/julienschmidt/sse => ../
+
+require (
+	github.com/julienschmidt/sse v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.46.0
+	go.opentelemetry.io/otel/metric v1.46.0
+	go.opentelemetry.io/otel/sdk v1.46.0
+	go.opentelemetry.io/otel/sdk/metric v1.46.0
+	go.opentelemetry.io/otel/trace v1.46.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/go-logr/logr v1.4.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	go.opentelemetry.io/auto/sdk v1.2.1 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+)
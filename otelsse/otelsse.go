@@ -0,0 +1,117 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+// Package otelsse records OpenTelemetry metrics and traces for a sse.Streamer.
+//
+// It is a separate module to keep the sse package free of dependencies.
+// Usage:
+//
+//	observer, err := otelsse.New(otelsse.WithMeterProvider(mp))
+//	if err != nil {
+//		// handle error
+//	}
+//	streamer := sse.MustNew(sse.WithObserver(observer))
+package otelsse
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/julienschmidt/sse/otelsse"
+
+// Observer implements sse.Observer. It records the following metrics:
+//
+//   - sse.connections: the number of active connections
+//   - sse.events: the number of sent events
+//   - sse.deliveries: the number of events passed to clients
+//
+// and a span for the duration of every connection.
+type Observer struct {
+	tracer      trace.Tracer
+	connections metric.Int64UpDownCounter
+	events      metric.Int64Counter
+	deliveries  metric.Int64Counter
+}
+
+// Option configures an Observer.
+type Option func(*config)
+
+type config struct {
+	meterProvider  metric.MeterProvider
+	tracerProvider trace.TracerProvider
+}
+
+// WithMeterProvider sets the MeterProvider used to record metrics.
+// The default is the global MeterProvider.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = mp
+	}
+}
+
+// WithTracerProvider sets the TracerProvider used to record connection spans.
+// The default is the global TracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = tp
+	}
+}
+
+// New returns a new Observer.
+func New(opts ...Option) (*Observer, error) {
+	c := config{
+		meterProvider:  otel.GetMeterProvider(),
+		tracerProvider: otel.GetTracerProvider(),
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	meter := c.meterProvider.Meter(instrumentationName)
+	o := &Observer{
+		tracer: c.tracerProvider.Tracer(instrumentationName),
+	}
+
+	var err error
+	o.connections, err = meter.Int64UpDownCounter("sse.connections",
+		metric.WithDescription("Number of active connections"))
+	if err != nil {
+		return nil, err
+	}
+	o.events, err = meter.Int64Counter("sse.events",
+		metric.WithDescription("Number of sent events"))
+	if err != nil {
+		return nil, err
+	}
+	o.deliveries, err = meter.Int64Counter("sse.deliveries",
+		metric.WithDescription("Number of events passed to clients"))
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Connect implements sse.Observer.
+func (o *Observer) Connect(r *http.Request) func() {
+	ctx, span := o.tracer.Start(r.Context(), "sse.connection",
+		trace.WithSpanKind(trace.SpanKindServer))
+	o.connections.Add(ctx, 1)
+
+	return func() {
+		o.connections.Add(context.Background(), -1)
+		span.End()
+	}
+}
+
+// Broadcast implements sse.Observer.
+func (o *Observer) Broadcast(clients int) {
+	ctx := context.Background()
+	o.events.Add(ctx, 1)
+	o.deliveries.Add(ctx, int64(clients))
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package otelsse
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/sse"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func sum(t *testing.T, rm metricdata.ResourceMetrics, name string) int64 {
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			var total int64
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				total += dp.Value
+			}
+			return total
+		}
+	}
+	t.Fatalf("metric %s not recorded", name)
+	return 0
+}
+
+func TestObserver(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	spans := tracetest.NewSpanRecorder()
+
+	observer, err := New(
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamer := sse.MustNew(sse.WithObserver(observer), sse.WithDirectBroadcast())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(httptest.NewRecorder(), r)
+		close(done)
+	}()
+	for streamer.ClientCount() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	streamer.SendString("", "", "a")
+	streamer.SendString("", "", "b")
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	if n := sum(t, rm, "sse.connections"); n != 1 {
+		t.Errorf("expected 1 connection, got: %d", n)
+	}
+	if n := sum(t, rm, "sse.events"); n != 2 {
+		t.Errorf("expected 2 events, got: %d", n)
+	}
+	if n := sum(t, rm, "sse.deliveries"); n != 2 {
+		t.Errorf("expected 2 deliveries, got: %d", n)
+	}
+
+	cancel()
+	<-done
+
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	if n := sum(t, rm, "sse.connections"); n != 0 {
+		t.Errorf("expected 0 connections, got: %d", n)
+	}
+	if ended := spans.Ended(); len(ended) != 1 || ended[0].Name() != "sse.connection" {
+		t.Errorf("expected 1 connection span, got: %v", ended)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"sync/atomic"
+)
+
+// WithMaxQueuedBytes limits the total size of the events buffered for all
+// clients to n bytes, which bounds the memory used during a broadcast storm
+// independent of the number of clients. When an event would exceed the limit,
+// the overflow policy applies to all clients instead of only to clients with a
+// full buffer:
+// With OverflowDrop, the event is dropped for the client.
+// With OverflowBlock, the clients with the most buffered bytes are
+// disconnected until the event fits, since blocking would not free any memory.
+// A limit of 0 disables it.
+func WithMaxQueuedBytes(n int) Option {
+	return func(s *Streamer) {
+		s.maxQueuedBytes = int64(n)
+	}
+}
+
+// reserve accounts size bytes to the events buffered for the client, see
+// WithMaxQueuedBytes. It returns false if the event must not be passed to the
+// client, either because it is dropped or because the client was
+// disconnected.
+func (s *Streamer) reserve(cl *client, size int64) bool {
+	for atomic.LoadInt64(&s.queuedBytes)+size > s.maxQueuedBytes {
+		if s.overflow == OverflowDrop {
+			return false
+		}
+		slowest := s.slowestClient()
+		if slowest == nil {
+			// the event alone exceeds the limit
+			break
+		}
+		s.evict(slowest)
+		if slowest == cl {
+			return false
+		}
+	}
+	atomic.AddInt64(&cl.queued, size)
+	atomic.AddInt64(&s.queuedBytes, size)
+	return true
+}
+
+// release accounts size bytes of the events buffered for the client as
+// written or discarded.
+func (s *Streamer) release(cl *client, size int64) {
+	if s.maxQueuedBytes > 0 {
+		atomic.AddInt64(&cl.queued, -size)
+		atomic.AddInt64(&s.queuedBytes, -size)
+	}
+}
+
+// releaseAll accounts all events still buffered for the client as discarded.
+func (s *Streamer) releaseAll(cl *client) {
+	if s.maxQueuedBytes > 0 {
+		atomic.AddInt64(&s.queuedBytes, -atomic.SwapInt64(&cl.queued, 0))
+	}
+}
+
+// slowestClient returns the client with the most buffered bytes, or nil if no
+// client has any buffered events.
+func (s *Streamer) slowestClient() (slowest *client) {
+	var max int64
+	for cl := range s.clients {
+		if queued := atomic.LoadInt64(&cl.queued); queued > max {
+			slowest, max = cl, queued
+		}
+	}
+	return
+}
+
+// evict disconnects the client from the run goroutine. Its buffered events
+// are discarded.
+func (s *Streamer) evict(cl *client) {
+	s.remove(cl)
+	s.releaseAll(cl)
+	close(cl.evicted)
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Every event of these tests has a size of 17 bytes.
+const queueTestData = "0123456789"
+
+func TestMaxQueuedBytesDrop(t *testing.T) {
+	streamer := MustNew(
+		WithDirectBroadcast(),
+		WithMaxQueuedBytes(50),
+		WithOverflowPolicy(OverflowDrop),
+		WithClientID(func(r *http.Request) string { return "paused" }),
+	)
+	streamer.BufSize(10)
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.PauseClient("paused")
+	for i := 0; i < 5; i++ {
+		streamer.SendString("", "", queueTestData)
+	}
+	if n := streamer.Stats().Dropped; n != 3 {
+		t.Errorf("expected 3 dropped events, got: %d", n)
+	}
+
+	// writing the buffered events frees the queue
+	streamer.ResumeClient("paused")
+	for i := 0; i < 2; i++ {
+		recv(t, w.writes)
+	}
+	streamer.SendString("", "", queueTestData)
+	recv(t, w.writes)
+	if n := streamer.Stats().Dropped; n != 3 {
+		t.Errorf("expected 3 dropped events, got: %d", n)
+	}
+}
+
+func TestMaxQueuedBytesDisconnect(t *testing.T) {
+	streamer := MustNew(
+		WithDirectBroadcast(),
+		WithMaxQueuedBytes(50),
+		WithClientID(func(r *http.Request) string { return r.Header.Get("X-Client") }),
+	)
+	streamer.BufSize(10)
+
+	slow := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	r.Header.Set("X-Client", "slow")
+	defer serve(t, streamer, slow, r, cancel)()
+	streamer.PauseClient("slow")
+
+	fast := NewMockChanWriteFlusher()
+	r, cancel = NewMockRequest()
+	r.Header.Set("X-Client", "fast")
+	defer serve(t, streamer, fast, r, cancel)()
+
+	streamer.SendString("", "", queueTestData)
+	recv(t, fast.writes)
+	if n := streamer.Stats().Clients; n != 2 {
+		t.Fatalf("expected 2 clients, has: %d", n)
+	}
+
+	// the slow client is disconnected to make room for the event
+	streamer.SendString("", "", queueTestData)
+	recv(t, fast.writes)
+	waitForClients(t, streamer, 1)
+	if len(slow.writes) != 0 {
+		t.Errorf("expected no writes to the slow client, got: %d", len(slow.writes))
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// ErrInvalidRaw is returned for raw events which are not well-formed, see
+// WithRawValidation.
+var ErrInvalidRaw = errors.New("sse: malformed raw event")
+
+// WithRawValidation enables checking that the bytes passed to SendRaw and
+// PumpRaw are well-formed events: every line must be a comment or a known
+// field, and the bytes must end with a blank line. Since the check scans all
+// bytes, it is meant as a debugging aid.
+func WithRawValidation() Option {
+	return func(s *Streamer) {
+		s.validateRaw = true
+	}
+}
+
+// SendRaw sends the given bytes verbatim to all connected clients. The bytes
+// must already be in the Server-Sent Events format, e.g. one or more events
+// read from an upstream stream, and should end with a blank line.
+// Unlike with SendEvent, the bytes are not decoded, thus they are neither
+// recorded in the history nor sent to clients of another framing, see
+// WithFraming, and the configured line ending and event prefix do not apply.
+// The bytes must not be modified after calling SendRaw.
+// SendRaw only returns an error if the bytes are not well-formed and
+// WithRawValidation is set.
+func (s *Streamer) SendRaw(p []byte) error {
+	if s.skip() {
+		return nil
+	}
+	if s.validateRaw && !validRaw(p) {
+		return ErrInvalidRaw
+	}
+	s.send(message{event: p, hint: true})
+	return nil
+}
+
+// PumpRaw sends each chunk received from events verbatim to all connected
+// clients with SendRaw, e.g. to fan out an upstream stream without decoding
+// and encoding every event.
+// PumpRaw returns nil when events is closed, or the error of ctx when it is
+// done. If WithRawValidation is set, it returns ErrInvalidRaw for the first
+// chunk which is not well-formed.
+func (s *Streamer) PumpRaw(ctx context.Context, events <-chan []byte) error {
+	for {
+		select {
+		case p, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := s.SendRaw(p); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// validRaw reports whether p consists of well-formed events with LF or CRLF
+// line endings.
+func validRaw(p []byte) bool {
+	if !bytes.HasSuffix(p, []byte("\n\n")) && !bytes.HasSuffix(p, []byte("\r\n\r\n")) {
+		return false
+	}
+	for _, line := range bytes.Split(p[:len(p)-1], []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 || line[0] == ':' {
+			continue
+		}
+		if bytes.IndexByte(line, '\r') >= 0 {
+			return false
+		}
+		field := line
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field = line[:i]
+		}
+		switch string(field) {
+		case "id", "event", "data", "retry":
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestValidRaw(t *testing.T) {
+	var tests = []struct {
+		raw   string
+		valid bool
+	}{
+		{"data:x\n\n", true},
+		{"id:1\nevent:msg\ndata:a\ndata:b\n\n", true},
+		{"data:x\r\n\r\n", true},
+		{": comment\nretry:1000\n\n", true},
+		{"data:a\n\ndata:b\n\n", true},
+		{"data\n\n", true},
+		{"data:x\n", false},
+		{"data:x", false},
+		{"", false},
+		{"foo:x\n\n", false},
+		{"data:a\rdata:b\n\n", false},
+	}
+	for _, test := range tests {
+		if valid := validRaw([]byte(test.raw)); valid != test.valid {
+			t.Errorf("%q: expected %v, got: %v", test.raw, test.valid, valid)
+		}
+	}
+}
+
+func TestPumpRaw(t *testing.T) {
+	streamer := MustNew(WithRawValidation())
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	chunks := []string{
+		"id:1\nevent:msg\ndata:a\n\n",
+		": upstream comment\ndata:b\r\n\r\n",
+		"data:c\n\ndata:d\n\n",
+	}
+	events := make(chan []byte, len(chunks)+1)
+	for _, chunk := range chunks {
+		events <- []byte(chunk)
+	}
+	close(events)
+	if err := streamer.PumpRaw(context.Background(), events); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, expected := range chunks {
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+
+	// malformed chunks stop the pump
+	events = make(chan []byte, 2)
+	events <- []byte("data:incomplete\n")
+	events <- []byte("data:x\n\n")
+	if err := streamer.PumpRaw(context.Background(), events); err != ErrInvalidRaw {
+		t.Error("expected ErrInvalidRaw, got:", err)
+	}
+	if len(events) != 1 {
+		t.Error("expected the remaining chunk not to be received")
+	}
+
+	// the pump stops when the context is done
+	ctx, cancelPump := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelPump()
+	if err := streamer.PumpRaw(ctx, make(chan []byte)); err != context.DeadlineExceeded {
+		t.Error("expected context.DeadlineExceeded, got:", err)
+	}
+}
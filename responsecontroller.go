@@ -0,0 +1,31 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+//go:build go1.20
+// +build go1.20
+
+package sse
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// setReadDeadline sets the read deadline of the connection of w via a
+// http.ResponseController.
+// It returns http.ErrNotSupported if w does not support deadlines.
+func setReadDeadline(w http.ResponseWriter, deadline time.Time) error {
+	err := http.NewResponseController(w).SetReadDeadline(deadline)
+	if errors.Is(err, http.ErrNotSupported) {
+		return http.ErrNotSupported
+	}
+	return err
+}
+
+// flushFunc returns a function which flushes w via a http.ResponseController,
+// which reports flush errors if supported by w.
+func flushFunc(w http.ResponseWriter, fl http.Flusher) func() error {
+	return http.NewResponseController(w).Flush
+}
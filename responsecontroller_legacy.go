@@ -0,0 +1,27 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+//go:build !go1.20
+// +build !go1.20
+
+package sse
+
+import (
+	"net/http"
+	"time"
+)
+
+// setReadDeadline requires http.ResponseController, which was added in Go 1.20.
+func setReadDeadline(w http.ResponseWriter, deadline time.Time) error {
+	return http.ErrNotSupported
+}
+
+// flushFunc returns a function which flushes w. Flush errors can only be
+// reported via http.ResponseController, which was added in Go 1.20.
+func flushFunc(w http.ResponseWriter, fl http.Flusher) func() error {
+	return func() error {
+		fl.Flush()
+		return nil
+	}
+}
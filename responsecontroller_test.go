@@ -0,0 +1,119 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+//go:build go1.20
+// +build go1.20
+
+package sse
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockDeadlineWriteFlusher supports setting read deadlines via a
+// http.ResponseController. Setting a deadline fails after failAfter calls.
+type mockDeadlineWriteFlusher struct {
+	mockResponseWriteFlusher
+	calls     *int32
+	failAfter int32
+}
+
+func (m mockDeadlineWriteFlusher) SetReadDeadline(deadline time.Time) error {
+	if atomic.AddInt32(m.calls, 1) > m.failAfter {
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func TestReadDeadline(t *testing.T) {
+	streamer := MustNew(WithReadDeadline(20 * time.Millisecond))
+	w := mockDeadlineWriteFlusher{NewMockResponseWriteFlusher(), new(int32), 3}
+	r, cancel := NewMockRequest()
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(w, r)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not return after the deadline could not be set")
+	}
+	if calls := atomic.LoadInt32(w.calls); calls != 4 {
+		t.Error("expected 4 calls, got:", calls)
+	}
+}
+
+func TestReadDeadlineTiny(t *testing.T) {
+	// half of the deadline rounds to 0, which must not panic
+	streamer := MustNew(WithReadDeadline(time.Nanosecond))
+	w := mockDeadlineWriteFlusher{NewMockResponseWriteFlusher(), new(int32), 3}
+	r, cancel := NewMockRequest()
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(w, r)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not return after the deadline could not be set")
+	}
+}
+
+func TestReadDeadlineNotSupported(t *testing.T) {
+	streamer := MustNew(WithReadDeadline(10 * time.Millisecond))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequestWithTimeout(100 * time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	streamer.ServeHTTP(w, r)
+	if time.Since(start) < 100*time.Millisecond {
+		t.Fatal("client without deadline support was disconnected")
+	}
+}
+
+// mockFlushErrorWriter accepts all writes, but fails to flush them.
+type mockFlushErrorWriter struct {
+	*mockResponseWriter
+}
+
+func (m mockFlushErrorWriter) Flush() {}
+
+func (m mockFlushErrorWriter) FlushError() error {
+	return errors.New("connection gone")
+}
+
+func TestFlushError(t *testing.T) {
+	streamer := MustNew()
+	w := mockFlushErrorWriter{NewMockResponseWriter()}
+	r, cancel := NewMockRequest()
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(w, r)
+		close(done)
+	}()
+	waitForClients(t, streamer, 1)
+
+	streamer.SendString("", "", "lost")
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not return after flushing failed")
+	}
+	waitForClients(t, streamer, 0)
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+var (
+	// ErrBufferFull is reported for clients which did not receive an event
+	// because their buffer was full, see WithOverflowPolicy and
+	// WithMaxQueuedBytes.
+	ErrBufferFull = errors.New("sse: client buffer full")
+
+	// ErrClientGone is reported for clients which were disconnected before
+	// an event was written to them.
+	ErrClientGone = errors.New("sse: client disconnected")
+
+	// ErrDuplicate is reported for all clients if an event was discarded
+	// because its ID was already sent, see WithDedup.
+	ErrDuplicate = errors.New("sse: duplicate event")
+)
+
+// ClientError describes a client which did not receive an event, see
+// SendEventResult.
+type ClientError struct {
+	ClientID string // the ID of the client, see WithClientID
+	Err      error
+}
+
+func (e ClientError) Error() string {
+	return "sse: client " + strconv.Quote(e.ClientID) + ": " + e.Err.Error()
+}
+
+// SendEventResult sends the given event to all connected clients, like
+// SendEventNow, and waits until it was written to each of them. It returns an
+// error for every client which did not receive the event, e.g. because the
+// write failed, its buffer was full or it was disconnected in the meantime.
+// This gives precise delivery accounting for critical events, at the cost of
+// waiting for the slowest client. Note that writes to paused clients only
+// happen once they are resumed, see PauseClient.
+// If the event is discarded as a duplicate, see WithDedup, ErrDuplicate is
+// returned for every connected client.
+// If the event is rejected, see WithMaxLineLength, or the Streamer is closed,
+// no client receives it and nil is returned.
+// The TTL of the event is ignored.
+func (s *Streamer) SendEventResult(e Event) []ClientError {
+	if s.skip() {
+		return nil
+	}
+	m := s.newMessage(e.ID, e.Event, s.formatBytes(e.ID, e.Event, e.Data), func() []byte {
+		return append([]byte(nil), e.Data...)
+	})
+	if m.event == nil {
+		return nil // rejected, see WithMaxLineLength
+	}
+	m.flush = true
+	res := &sendResult{dispatched: make(chan struct{})}
+	m.result = res
+	s.send(m)
+
+	select {
+	case <-res.dispatched:
+	case <-s.closed:
+		// Events are dispatched before the Streamer is closed, if at all
+		select {
+		case <-res.dispatched:
+		default:
+			return nil
+		}
+	}
+
+	errs := res.errs
+	for i := 0; i < res.pending; i++ {
+		if reply := <-res.replies; reply.Err != nil {
+			errs = append(errs, reply)
+		}
+	}
+	return errs
+}
+
+// sendResult collects the outcome of sending an event to each client, see
+// SendEventResult. A nil *sendResult collects nothing.
+type sendResult struct {
+	dispatched chan struct{} // closed when the event was passed to all clients
+
+	// written while dispatching before dispatched is closed, possibly by
+	// multiple shards, see WithShards
+	mu      sync.Mutex
+	errs    []ClientError    // clients which were not passed the event
+	pending int              // clients which were passed the event
+	replies chan ClientError // the outcome for each pending client
+}
+
+// init prepares the result for dispatching the event to at most n clients.
+func (res *sendResult) init(n int) {
+	if res != nil {
+		res.replies = make(chan ClientError, n)
+	}
+}
+
+// done marks the event as passed to all clients.
+func (res *sendResult) done() {
+	if res != nil {
+		close(res.dispatched)
+	}
+}
+
+// queued records that the event was passed to the buffer of the client.
+func (res *sendResult) queued() {
+	if res != nil {
+		res.mu.Lock()
+		res.pending++
+		res.mu.Unlock()
+	}
+}
+
+// dropped records that the event was not passed to the client.
+func (res *sendResult) dropped(cl *client, err error) {
+	if res != nil {
+		res.mu.Lock()
+		res.errs = append(res.errs, ClientError{cl.id, err})
+		res.mu.Unlock()
+	}
+}
+
+// reply reports the outcome for a client the event was passed to. A nil err
+// means the event was written.
+func (res *sendResult) reply(cl *client, err error) {
+	if res != nil {
+		res.replies <- ClientError{cl.id, err}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSendEventResult(t *testing.T) {
+	streamer := MustNew(
+		WithOverflowPolicy(OverflowDrop),
+		WithClientID(func(r *http.Request) string {
+			return r.Header.Get("X-Client")
+		}),
+	)
+	connect := func(id string, w http.ResponseWriter) (stop func()) {
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-Client", id)
+		return serve(t, streamer, w, r, cancel)
+	}
+
+	// fill the buffer of a client whose write blocks
+	full := NewMockBlockingWriteFlusher()
+	defer connect("full", full)()
+	defer close(full.unblock)
+	streamer.SendString("", "", "blocked")
+	<-full.writing
+	streamer.SendString("", "", "buffered")
+	streamer.SendString("", "", "buffered")
+
+	healthy := NewMockChanWriteFlusher()
+	defer connect("healthy", healthy)()
+	failing := mockWriteErrorFlusher{NewMockResponseWriter()}
+	r, cancel := NewMockRequest()
+	defer cancel()
+	r.Header.Set("X-Client", "failing")
+	go streamer.ServeHTTP(failing, r)
+	waitForClients(t, streamer, 3)
+
+	errs := streamer.SendEventResult(Event{Event: "critical", Data: []byte("x")})
+	byClient := make(map[string]error)
+	for _, err := range errs {
+		byClient[err.ClientID] = err.Err
+	}
+	if len(errs) != 2 || len(byClient) != 2 {
+		t.Fatalf("expected 2 client errors, got: %v", errs)
+	}
+	if err := byClient["failing"]; err == nil || err.Error() != "broken pipe" {
+		t.Errorf("expected the write error of the failing client, got: %v", err)
+	}
+	if err := byClient["full"]; err != ErrBufferFull {
+		t.Errorf("expected ErrBufferFull for the full client, got: %v", err)
+	}
+	if got := recv(t, healthy.writes); got != "event:critical\ndata:x\n\n" {
+		t.Errorf("expected the event for the healthy client, got: %q", got)
+	}
+
+	// no errors once only the healthy client receives events
+	waitForClients(t, streamer, 2)
+	if errs := streamer.SendEventResult(Event{Data: []byte("y")}); len(errs) != 1 || errs[0].ClientID != "full" {
+		t.Errorf("expected only the full client to fail, got: %v", errs)
+	}
+}
+
+func TestSendEventResultDisconnect(t *testing.T) {
+	streamer := MustNew(WithClientID(func(r *http.Request) string {
+		return "paused"
+	}))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+
+	// the event remains buffered until the client disconnects
+	streamer.PauseClient("paused")
+	result := make(chan []ClientError)
+	go func() {
+		result <- streamer.SendEventResult(Event{Data: []byte("x")})
+	}()
+	waitFor(t, func() bool {
+		metrics := streamer.ClientMetrics()
+		return len(metrics) == 1 && metrics[0].Buffered == 1
+	})
+	stop()
+	if errs := <-result; len(errs) != 1 || errs[0].Err != ErrClientGone {
+		t.Errorf("expected ErrClientGone, got: %v", errs)
+	}
+}
+
+func TestSendEventResultRejected(t *testing.T) {
+	streamer := MustNew(WithMaxLineLength(3, LineReject))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	defer serve(t, streamer, w, r, cancel)()
+
+	done := make(chan []ClientError)
+	go func() {
+		done <- streamer.SendEventResult(Event{Data: []byte("too long")})
+	}()
+	select {
+	case errs := <-done:
+		if errs != nil {
+			t.Errorf("expected no client errors, got: %v", errs)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SendEventResult blocked for a rejected event")
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidRetry is returned for reconnection times which are not positive.
+var ErrInvalidRetry = errors.New("sse: reconnection time must be positive")
+
+// WithRetry sets the reconnection time sent to every client when it connects.
+// Clients wait this long before reconnecting after the connection was lost.
+// The time is sent in milliseconds, shorter durations are rounded to the
+// nearest millisecond, but at least 1ms.
+// If d is not positive, New returns ErrInvalidRetry.
+func WithRetry(d time.Duration) Option {
+	return func(s *Streamer) {
+		if d <= 0 {
+			s.invalid(ErrInvalidRetry)
+			return
+		}
+		s.retry = int64(d)
+	}
+}
+
+// SetRetry sets the reconnection time like WithRetry and additionally sends
+// it to all connected clients.
+// If d is not positive, ErrInvalidRetry is returned.
+func (s *Streamer) SetRetry(d time.Duration) error {
+	if d <= 0 {
+		return ErrInvalidRetry
+	}
+	atomic.StoreInt64(&s.retry, int64(d))
+	if s.skip() {
+		return nil
+	}
+	s.send(message{
+		event: s.formatRetry(d),
+		hint:  true,
+	})
+	return nil
+}
+
+// formatRetry serializes a reconnection time hint. The spec requires an
+// integer number of milliseconds, thus the duration is rounded to the nearest
+// millisecond, but at least 1ms.
+func (s *Streamer) formatRetry(retry time.Duration) []byte {
+	ms := int64((retry + time.Millisecond/2) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	p := strconv.AppendInt([]byte("retry:"), ms, 10)
+	return s.endEvent(p)
+}
+
+// WithAdaptiveRetry enables escalating the reconnection time sent to clients
+// which reconnect repeatedly, e.g. during an outage, to spread their
+// reconnections. Clients are sent min when they connect. A client connecting
+// again within twice max of its previous connection is sent twice its previous
+// reconnection time, up to max.
+// Clients are identified by their client ID, see WithClientID, or else by
+// their remote IP address.
+// If min is not positive or max is less than min, New returns ErrInvalidRetry.
+func WithAdaptiveRetry(min, max time.Duration) Option {
+	return func(s *Streamer) {
+		if min <= 0 || max < min {
+			s.invalid(ErrInvalidRetry)
+			return
+		}
+		s.adaptiveRetry = &adaptiveRetry{
+			min:     min,
+			max:     max,
+			clients: make(map[string]recentConnect),
+		}
+	}
+}
+
+// adaptiveRetry tracks the recent connections of clients, see
+// WithAdaptiveRetry.
+type adaptiveRetry struct {
+	min, max time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]recentConnect
+	lastPrune time.Time
+}
+
+type recentConnect struct {
+	at    time.Time
+	retry time.Duration
+}
+
+// next returns the reconnection time for a client with the given key
+// connecting at the given time.
+func (a *adaptiveRetry) next(key string, now time.Time) time.Duration {
+	window := 2 * a.max
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Forget clients which did not connect recently
+	if now.Sub(a.lastPrune) > window {
+		for k, c := range a.clients {
+			if now.Sub(c.at) > window {
+				delete(a.clients, k)
+			}
+		}
+		a.lastPrune = now
+	}
+
+	retry := a.min
+	if c, ok := a.clients[key]; ok && now.Sub(c.at) <= window {
+		retry = 2 * c.retry
+		if retry > a.max {
+			retry = a.max
+		}
+	}
+	a.clients[key] = recentConnect{at: now, retry: retry}
+	return retry
+}
+
+// retryKey returns the key identifying the client of the request for
+// adaptive reconnection times.
+func retryKey(cl *client, r *http.Request) string {
+	if cl.id != "" {
+		return cl.id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
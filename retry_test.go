@@ -0,0 +1,106 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetRetry(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	var tests = []struct {
+		retry    time.Duration
+		expected string
+		err      error
+	}{
+		{0, "", ErrInvalidRetry},
+		{-time.Second, "", ErrInvalidRetry},
+		{500 * time.Microsecond, "retry:1\n\n", nil},
+		{1499 * time.Microsecond, "retry:1\n\n", nil},
+		{1500 * time.Microsecond, "retry:2\n\n", nil},
+		{3 * time.Second, "retry:3000\n\n", nil},
+	}
+	for _, test := range tests {
+		if err := streamer.SetRetry(test.retry); err != test.err {
+			t.Errorf("SetRetry(%v): expected error %v, got: %v", test.retry, test.err, err)
+		}
+		if test.err != nil {
+			continue
+		}
+		if got := recv(t, w.writes); got != test.expected {
+			t.Errorf("SetRetry(%v): expected %q, got: %q", test.retry, test.expected, got)
+		}
+	}
+
+	// new clients receive the current reconnection time when they connect
+	w2 := NewMockChanWriteFlusher()
+	r2, cancel2 := NewMockRequest()
+	stop2 := serve(t, streamer, w2, r2, cancel2)
+	defer stop2()
+	if got := recv(t, w2.writes); got != "retry:3000\n\n" {
+		t.Errorf("expected the reconnection time, got: %q", got)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	streamer := MustNew(WithRetry(250 * time.Millisecond))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+	if got := recv(t, w.writes); got != "retry:250\n\n" {
+		t.Errorf("expected the reconnection time, got: %q", got)
+	}
+
+	for _, d := range []time.Duration{0, -time.Millisecond} {
+		if _, err := New(WithRetry(d)); err != ErrInvalidRetry {
+			t.Errorf("expected ErrInvalidRetry for %v, got: %v", d, err)
+		}
+	}
+}
+
+func TestAdaptiveRetry(t *testing.T) {
+	streamer := MustNew(WithAdaptiveRetry(time.Second, 3*time.Second))
+	a := streamer.adaptiveRetry
+
+	now := time.Now()
+	var tests = []struct {
+		key      string
+		after    time.Duration
+		expected time.Duration
+	}{
+		{"a", 0, time.Second},                // first connect
+		{"a", time.Second, 2 * time.Second},  // rapid reconnect
+		{"a", time.Second, 3 * time.Second},  // capped at max
+		{"b", 0, time.Second},                // other client
+		{"a", time.Second, 3 * time.Second},  // still capped
+		{"a", 7 * time.Second, time.Second},  // not recent anymore
+		{"b", 10 * time.Second, time.Second}, // forgotten
+	}
+	for i, test := range tests {
+		now = now.Add(test.after)
+		if retry := a.next(test.key, now); retry != test.expected {
+			t.Errorf("%d: expected %v, got: %v", i, test.expected, retry)
+		}
+	}
+
+	// rapid reconnects of the same client via HTTP
+	for _, expected := range []string{"retry:1000\n\n", "retry:2000\n\n"} {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.RemoteAddr = "192.0.2.1:1234"
+		stop := serve(t, streamer, w, r, cancel)
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+		stop()
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithServerTiming enables sending a Server-Timing header to each connecting
+// client, which reports how long the setup of the connection took before the
+// stream started, e.g. to find slow authorizers in the network panel of the
+// browser. The header contains the metrics
+//   - auth: the duration of the authorizer, see WithAuthorizer
+//   - connect: the duration of connecting the client, which includes loading
+//     the missed events, see WithHistory
+//   - resume: the duration of resolving the resume token, see
+//     WithResumeResolver
+//   - backlog: the duration of loading the backlog, see WithBacklog
+//
+// in milliseconds. Metrics of steps which are not configured are omitted.
+func WithServerTiming() Option {
+	return func(s *Streamer) {
+		s.serverTiming = true
+	}
+}
+
+// serverTiming measures the durations of the steps of the connection setup,
+// see WithServerTiming. A nil *serverTiming measures nothing.
+type serverTiming struct {
+	metrics []string
+	last    time.Time
+}
+
+// newServerTiming starts measuring if Server-Timing is enabled.
+func (s *Streamer) newServerTiming() *serverTiming {
+	if !s.serverTiming {
+		return nil
+	}
+	return &serverTiming{last: time.Now()}
+}
+
+// skip excludes the time since the previous step from the next one.
+func (t *serverTiming) skip() {
+	if t != nil {
+		t.last = time.Now()
+	}
+}
+
+// mark records the time since the previous step as the duration of the step
+// with the given name.
+func (t *serverTiming) mark(name string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	ms := float64(now.Sub(t.last)) / float64(time.Millisecond)
+	t.metrics = append(t.metrics, name+";dur="+strconv.FormatFloat(ms, 'f', 1, 64))
+	t.last = now
+}
+
+// String returns the value of the Server-Timing header.
+func (t *serverTiming) String() string {
+	return strings.Join(t.metrics, ", ")
+}
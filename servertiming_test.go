@@ -0,0 +1,74 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTiming(t *testing.T) {
+	streamer := MustNew(
+		WithServerTiming(),
+		WithAuthorizer(func(r *http.Request) (bool, int) {
+			time.Sleep(20 * time.Millisecond)
+			return true, 0
+		}),
+		WithBacklog(func(r *http.Request) []Event {
+			time.Sleep(10 * time.Millisecond)
+			return []Event{{Data: []byte("backlog")}}
+		}),
+	)
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	if got := recv(t, w.writes); got != "data:backlog\n\n" {
+		t.Errorf("expected the backlog, got: %q", got)
+	}
+
+	header := w.Header().Get("Server-Timing")
+	metrics := strings.Split(header, ", ")
+	if len(metrics) != 3 {
+		t.Fatalf("expected 3 metrics, got: %q", header)
+	}
+	for i, expected := range []struct {
+		name string
+		min  float64
+	}{
+		{"auth", 20},
+		{"connect", 0},
+		{"backlog", 10},
+	} {
+		parts := strings.SplitN(metrics[i], ";dur=", 2)
+		if len(parts) != 2 || parts[0] != expected.name {
+			t.Errorf("expected metric %q, got: %q", expected.name, metrics[i])
+			continue
+		}
+		dur, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || dur < expected.min || dur > 1000 {
+			t.Errorf("implausible duration of %q: %q", expected.name, parts[1])
+		}
+	}
+}
+
+func TestServerTimingDisabled(t *testing.T) {
+	streamer := MustNew(WithBacklog(func(r *http.Request) []Event {
+		return []Event{{Data: []byte("backlog")}}
+	}))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	recv(t, w.writes)
+	if header, ok := w.Header()["Server-Timing"]; ok {
+		t.Errorf("expected no Server-Timing header, got: %q", header)
+	}
+}
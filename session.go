@@ -0,0 +1,125 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// SessionReplacedEvent is the event type of the final event sent to a client
+// whose connection is replaced by a newer connection, see
+// WithSingleSessionPerClient.
+const SessionReplacedEvent = "session-replaced"
+
+// WithSingleSessionPerClient allows only a single connection per client
+// identity, e.g. a user or session id derived from the request. When a client
+// connects with the identity of a connected client, the older connection is
+// sent an event of type SessionReplacedEvent without data and is closed.
+// Its buffered events are discarded.
+// Since browsers reconnect automatically, the old client should close its
+// EventSource when receiving the event, otherwise both connections keep
+// replacing each other.
+// Clients for which the function returns an empty string are not limited.
+func WithSingleSessionPerClient(key func(r *http.Request) string) Option {
+	return func(s *Streamer) {
+		s.sessionKey = key
+	}
+}
+
+// replaceSession closes the connection of the client with the same session as
+// cl, if any, see WithSingleSessionPerClient.
+func (s *Streamer) replaceSession(cl *client) {
+	if cl.session == "" {
+		return
+	}
+	if old := s.sessions[cl.session]; old != nil {
+		old.final = s.frame(old.framing, Event{Event: SessionReplacedEvent})
+		s.evict(old)
+	}
+}
+
+// Session is a handle for sending events to the single client connection of a
+// request, e.g. from a worker goroutine started by the handler serving it.
+type Session struct {
+	s    *Streamer
+	cl   *client
+	done <-chan struct{}
+}
+
+// Session returns a handle for sending events only to the client connected by
+// serving r with ServeHTTP. It is meant to be called by a handler before it
+// passes the request to ServeHTTP, thus r must be the very same request and
+// not a copy, e.g. made by http.Request.WithContext.
+// Events sent before the client is connected or after it was disconnected are
+// discarded. When the request ends, the handle is unregistered automatically,
+// thus the context of r must be cancellable, which it is for requests of a
+// http.Server. Otherwise, a handle of a request which is never served is only
+// unregistered when the Streamer is closed.
+func (s *Streamer) Session(r *http.Request) *Session {
+	cl := s.newClient()
+	s.query(func() {
+		if s.requests[r] == nil {
+			atomic.AddInt64(&s.sessionCount, 1)
+		}
+		s.requests[r] = cl
+	})
+	done := r.Context().Done()
+	go func() {
+		select {
+		case <-done:
+		case <-cl.gone:
+		case <-s.closed:
+		}
+		s.query(func() {
+			if s.requests[r] == cl {
+				delete(s.requests, r)
+				atomic.AddInt64(&s.sessionCount, -1)
+			}
+		})
+	}()
+	return &Session{s: s, cl: cl, done: done}
+}
+
+// takeSessionClient returns the client created for the request by Session,
+// or a new client if there is none.
+// The lookup is skipped if no Session is pending, so that serving requests
+// does not wait for the run goroutine.
+func (s *Streamer) takeSessionClient(r *http.Request) (cl *client) {
+	if atomic.LoadInt64(&s.sessionCount) > 0 {
+		s.query(func() {
+			if cl = s.requests[r]; cl != nil {
+				delete(s.requests, r)
+				atomic.AddInt64(&s.sessionCount, -1)
+			}
+		})
+	}
+	if cl == nil {
+		cl = s.newClient()
+	}
+	return
+}
+
+// Send sends the given event only to the client of the session, like
+// SendEvent.
+func (sess *Session) Send(e Event) {
+	s := sess.s
+	p := s.formatBytes(e.ID, e.Event, e.Data)
+	if p == nil {
+		return
+	}
+	m := s.newMessage(e.ID, e.Event, p, func() []byte {
+		return append([]byte(nil), e.Data...)
+	})
+	m.expires = expiry(e.TTL)
+	m.to = sess.cl
+	s.send(m)
+}
+
+// Done returns a channel which is closed when the request of the session
+// ends, after which sent events are discarded.
+func (sess *Session) Done() <-chan struct{} {
+	return sess.done
+}
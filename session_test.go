@@ -0,0 +1,143 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleSessionPerClient(t *testing.T) {
+	streamer := MustNew(WithSingleSessionPerClient(func(r *http.Request) string {
+		return r.Header.Get("X-Session")
+	}))
+
+	connect := func(session string) (w mockChanWriteFlusher, done chan struct{}) {
+		w = NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-Session", session)
+		done = make(chan struct{})
+		go func() {
+			defer cancel()
+			streamer.ServeHTTP(w, r)
+			close(done)
+		}()
+		return
+	}
+
+	first, firstDone := connect("a")
+	waitForClients(t, streamer, 1)
+	other, otherDone := connect("b")
+	waitForClients(t, streamer, 2)
+
+	// the second connection of session a replaces the first one
+	second, secondDone := connect("a")
+	if got := recv(t, first.writes); got != "event:session-replaced\ndata\n\n" {
+		t.Errorf("wrong final event, got: %q", got)
+	}
+	select {
+	case <-firstDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("replaced connection was not closed")
+	}
+	waitForClients(t, streamer, 2)
+
+	streamer.SendString("", "", "live")
+	for _, w := range []mockChanWriteFlusher{second, other} {
+		if got := recv(t, w.writes); got != "data:live\n\n" {
+			t.Errorf("wrong event, got: %q", got)
+		}
+	}
+
+	streamer.Close()
+	<-secondDone
+	<-otherDone
+}
+
+func TestSession(t *testing.T) {
+	streamer := MustNew(WithDirectBroadcast())
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	sess := streamer.Session(r)
+	stop := serve(t, streamer, w, r, cancel)
+	if n := atomic.LoadInt64(&streamer.sessionCount); n != 0 {
+		t.Errorf("served session still pending, count: %d", n)
+	}
+
+	other := NewMockChanWriteFlusher()
+	r, cancel = NewMockRequest()
+	defer serve(t, streamer, other, r, cancel)()
+
+	sess.Send(Event{Event: "own", Data: []byte("x")})
+	streamer.SendString("", "", "all")
+	for _, expected := range []string{"event:own\ndata:x\n\n", "data:all\n\n"} {
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+	if got := recv(t, other.writes); got != "data:all\n\n" {
+		t.Errorf("the event of the session reached another client, got: %q", got)
+	}
+
+	// the session is unregistered when its request ends
+	stop()
+	select {
+	case <-sess.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("session not done")
+	}
+	waitFor(t, func() bool {
+		var n int
+		streamer.query(func() {
+			n = len(streamer.requests)
+		})
+		return n == 0 && atomic.LoadInt64(&streamer.sessionCount) == 0
+	})
+	sess.Send(Event{Data: []byte("gone")})
+	streamer.SendString("", "", "live")
+	if got := recv(t, other.writes); got != "data:live\n\n" {
+		t.Errorf("expected the live event, got: %q", got)
+	}
+	select {
+	case got := <-w.writes:
+		t.Errorf("unexpected write after the session ended: %q", got)
+	default:
+	}
+}
+
+func TestSessionNotCancellable(t *testing.T) {
+	before := runtime.NumGoroutine()
+	streamer := MustNew()
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamer.Session(r)
+
+	// the cleanup goroutine ends when the Streamer is closed
+	streamer.Close()
+	waitFor(t, func() bool {
+		return runtime.NumGoroutine() <= before
+	})
+}
+
+func TestSessionNotServed(t *testing.T) {
+	streamer := MustNew()
+	r, cancel := NewMockRequest()
+	streamer.Session(r)
+	cancel()
+
+	waitFor(t, func() bool {
+		var n int
+		streamer.query(func() {
+			n = len(streamer.requests)
+		})
+		return n == 0 && atomic.LoadInt64(&streamer.sessionCount) == 0
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"sync"
+)
+
+// WithShards partitions the connected clients into n shards, each with its own
+// goroutine passing the broadcast events to its clients. This parallelizes the
+// broadcast, which otherwise becomes a bottleneck at tens of thousands of
+// clients. Events are still broadcast one after another, in the same order.
+// Sharding only pays off with multiple CPUs and many clients; compare the
+// broadcast benchmarks for the target machine.
+// Events for groups and clients whose queued bytes are limited, see
+// WithMaxQueuedBytes, are not sharded.
+// A number of 0 or 1 disables sharding.
+func WithShards(n int) Option {
+	return func(s *Streamer) {
+		s.shardCount = n
+	}
+}
+
+// shard is a subset of the clients, to which its own goroutine passes the
+// broadcast events, see WithShards.
+type shard struct {
+	// clients is written by the run goroutine and read by the goroutine of
+	// the shard only while the run goroutine waits for it.
+	clients map[*client]bool
+	work    chan shardWork
+}
+
+// shardWork is a message to be passed to the clients of a shard.
+type shardWork struct {
+	m         *message
+	eventType string // see addressed
+	done      *sync.WaitGroup
+}
+
+// runShards starts the goroutines of the shards.
+func (s *Streamer) runShards() {
+	s.shards = make([]*shard, s.shardCount)
+	for i := range s.shards {
+		sh := &shard{
+			clients: make(map[*client]bool),
+			work:    make(chan shardWork),
+		}
+		s.shards[i] = sh
+		go func() {
+			for w := range sh.work {
+				for cl := range sh.clients {
+					if s.addressed(cl, w.m, w.eventType) {
+						s.deliver(cl, *w.m)
+					}
+				}
+				w.done.Done()
+			}
+		}()
+	}
+}
+
+// stopShards terminates the goroutines of the shards.
+func (s *Streamer) stopShards() {
+	for _, sh := range s.shards {
+		close(sh.work)
+	}
+}
+
+// broadcastShards passes the message to the addressed clients of all shards
+// in parallel and waits until all shards are done.
+func (s *Streamer) broadcastShards(m *message, eventType string) {
+	var wg sync.WaitGroup
+	wg.Add(len(s.shards))
+	for _, sh := range s.shards {
+		sh.work <- shardWork{m, eventType, &wg}
+	}
+	wg.Wait()
+}
+
+// addToShard adds a client to the shard with the fewest clients.
+func (s *Streamer) addToShard(cl *client) {
+	if s.shards == nil {
+		return
+	}
+	for i, sh := range s.shards {
+		if len(sh.clients) < len(s.shards[cl.shard].clients) {
+			cl.shard = i
+		}
+	}
+	s.shards[cl.shard].clients[cl] = true
+}
+
+// removeFromShard removes a client from its shard.
+func (s *Streamer) removeFromShard(cl *client) {
+	if s.shards != nil {
+		delete(s.shards[cl.shard].clients, cl)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestShards(t *testing.T) {
+	const clients = 20
+	streamer := MustNew(
+		WithShards(4),
+		WithClientID(func(r *http.Request) string {
+			return r.Header.Get("X-Client")
+		}),
+	)
+
+	writers := make([]mockChanWriteFlusher, clients)
+	for i := range writers {
+		writers[i] = NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-Client", strconv.Itoa(i))
+		defer serve(t, streamer, writers[i], r, cancel)()
+	}
+
+	// the clients are spread evenly across the shards
+	streamer.query(func() {
+		for i, sh := range streamer.shards {
+			if n := len(sh.clients); n != clients/4 {
+				t.Errorf("shard %d: expected %d clients, got: %d", i, clients/4, n)
+			}
+		}
+	})
+
+	for i := 0; i < 10; i++ {
+		streamer.SendString("", "", strconv.Itoa(i))
+	}
+	streamer.SendStringExcept("0", "", "", "except")
+	if errs := streamer.SendEventResult(Event{Data: []byte("result")}); len(errs) != 0 {
+		t.Errorf("unexpected client errors: %v", errs)
+	}
+	for c, w := range writers {
+		for i := 0; i < 10; i++ {
+			if got := recv(t, w.writes); got != "data:"+strconv.Itoa(i)+"\n\n" {
+				t.Errorf("client %d: expected event %d, got: %q", c, i, got)
+			}
+		}
+		if c != 0 {
+			if got := recv(t, w.writes); got != "data:except\n\n" {
+				t.Errorf("client %d: expected the except event, got: %q", c, got)
+			}
+		}
+		if got := recv(t, w.writes); got != "data:result\n\n" {
+			t.Errorf("client %d: expected the result event, got: %q", c, got)
+		}
+	}
+
+	// disconnected clients are removed from their shard
+	streamer.Close()
+	streamer.query(func() {
+		for i, sh := range streamer.shards {
+			if n := len(sh.clients); n != 0 {
+				t.Errorf("shard %d: expected no clients, got: %d", i, n)
+			}
+		}
+	})
+}
+
+func benchmarkShards(b *testing.B, shards int) {
+	const clients = 10000
+	streamer := MustNew(WithDirectBroadcast(), WithShards(shards))
+	for i := 0; i < clients; i++ {
+		defer streamer.AddWriter(ioutil.Discard)()
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// returns after the event was passed to all clients
+		streamer.SendString("", "", "bench")
+	}
+}
+
+func BenchmarkBroadcast10kClients1Shard(b *testing.B)  { benchmarkShards(b, 1) }
+func BenchmarkBroadcast10kClients4Shards(b *testing.B) { benchmarkShards(b, 4) }
@@ -9,37 +9,250 @@ package sse
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type client chan []byte
+type client struct {
+	queued   int64 // accessed atomically, must be 64-bit aligned
+	ch       chan message
+	prio     chan message      // high priority events
+	group    string            // see WithGroupKey
+	id       string            // see WithClientID
+	headers  map[string]string // see WithIndexedHeaders
+	subs     *subscriptions    // see WithTopicParam
+	types    map[string]bool   // see WithEventTypeParam, nil for all types
+	framing  Framing           // see WithFraming
+	limitKey string            // see WithMaxConnectionsPerClient
+	session  string            // see WithSingleSessionPerClient
+	shard    int               // the index of the shard, see WithShards
+	dropped  uint64            // events dropped due to a full buffer
+	sent     uint64            // events passed to the buffer
+	paused   int32             // accessed atomically, see PauseClient
+	wake     chan struct{}     // signals a change of paused
+	evicted  chan struct{}     // closed when disconnected by the server
+	final    []byte            // written when evicted, if set
+	drained  bool              // write the buffered events when evicted, see DrainTopic
+	gone     chan struct{}     // closed when disconnected
+	since    time.Time         // when the client was registered
+
+	disconnectOnce sync.Once
+}
+
+// message is an event passed to the run goroutine.
+type message struct {
+	event   []byte      // serialized event
+	frame   []byte      // serialized event in the alternative framing, if any
+	e       Event       // the event, its data is only set if needed
+	batch   []Event     // the events of a batch, only set if needed, see SendBatch
+	prio    bool        // high priority
+	retain  bool        // retain as the latest event of its type
+	flush   bool        // flush immediately, see SendEventNow
+	hint    bool        // not an event, e.g. a reconnection time or raw bytes
+	batched bool        // several events sent as a unit, see SendBatch
+	group   string      // only send to the clients of this group if set
+	except  string      // do not send to the clients with this ID if set
+	header  string      // only send to clients with this header value if set
+	value   string      // the value of header
+	topic   string      // only send to the subscribers of this topic if set
+	to      *client     // only send to this client if set, see Session
+	result  *sendResult // collects the outcome if set, see SendEventResult
+	expires time.Time   // the event is not written after this time if set
+}
+
+// Event is a single Server-Sent Event.
+// If the ID or Event string is empty, no id / event type is send.
+type Event struct {
+	ID    string
+	Event string
+	Data  []byte
+
+	// TTL is the time to live of the event. Events still waiting in the
+	// buffer of a slow client when their TTL passed are dropped for that
+	// client, e.g. for live data where stale events are worse than none.
+	// 0 means the event does not expire. The TTL is only used by SendEvent,
+	// SendEventNow and SendBatch.
+	TTL time.Duration
+}
 
 // Streamer receives events and broadcasts them to all connected clients.
 // Streamer is a http.Handler. Clients making a request to this handler receive
 // a stream of Server-Sent Events, which can be handled via JavaScript.
 // See the linked technical specification for details.
+//
+// A Streamer is safe for concurrent use by multiple goroutines. Events sent
+// concurrently by multiple producers are broadcast in some interleaving, but
+// each event is written to a client as a whole, never mixed with another
+// event. The events sent by a single goroutine are written to every client in
+// the order in which they were sent, except that high priority events may
+// overtake events of normal priority, see SendStringPriority. Events may be
+// skipped for a client, e.g. if its buffer is full, see WithOverflowPolicy.
 type Streamer struct {
-	event         chan []byte
-	clients       map[client]bool
-	connecting    chan client
-	disconnecting chan client
-	bufSize       uint
+	bufSize       uint64 // accessed atomically, must be 64-bit aligned
+	clientCount   int64  // accessed atomically, must be 64-bit aligned
+	retry         int64  // accessed atomically, must be 64-bit aligned
+	queuedBytes   int64  // accessed atomically, must be 64-bit aligned
+	lastID        uint64 // accessed atomically, must be 64-bit aligned
+	dropped       uint64 // total number of dropped events, accessed atomically
+	firstEvents   uint64 // clients which received an event, accessed atomically
+	firstEventSum int64  // sum of the times to the first event, accessed atomically
+	firstEventMax int64  // accessed atomically, must be 64-bit aligned
+	sessionCount  int64  // entries of requests, accessed atomically
+	event         chan message
+	queueSize     int
+	clients       map[*client]bool
+	disconnecting chan *client
+	queries       chan func()
+	pings         chan struct{} // liveness checks, see Healthy
+	retained      map[string]message
+	groups        map[string]map[*client]bool
+	conns         map[string]int // connections per limit key
+	sessions      map[string]*client
+	requests      map[*http.Request]*client // clients created by Session
+	held          map[string]*heldClient    // see WithReconnectGrace
+	topicRetained map[string]message        // latest event per topic, see WithTopicRetention
+	drainedTopics map[string]bool           // see DrainTopic
+	history       HistoryStore
+	autoID        bool // see WithInitialID
+	shardCount    int
+	shards        []*shard      // nil if not sharded, see WithShards
+	closing       bool          // Close was called
+	closed        chan struct{} // closed by Close
+	stopped       chan struct{} // closed when all clients left after Close
+	keepData      bool          // keep the event data in messages, see newMessage
+
+	// direct broadcast mode, see WithDirectBroadcast
+	direct    bool
+	skipEmpty bool
+	mu        sync.Mutex // guards the run goroutine state in direct mode
+
+	authorize    func(r *http.Request) (bool, int)
+	groupKey     func(r *http.Request) string
+	clientID     func(r *http.Request) string
+	limitKey     func(r *http.Request) string
+	limitConns   int
+	sessionKey   func(r *http.Request) string
+	headers      []string // canonical keys of the indexed headers
+	topicParam   string
+	typeParam    string
+	backlog      func(r *http.Request) []Event
+	resume       func(token string) []Event
+	resumeHeader string
+	preamble     []Event
+	prefix       string
+	singleLine   bool
+	route        func(e Event) string
+
+	contentType    string
+	lineEnding     string
+	flushEvents    int
+	flushInterval  time.Duration
+	plainErrors    bool
+	bufferingCheck bool
+	maxLineLength  int
+	linePolicy     LinePolicy
+	timeFormat     TimeFormat
+	timeSync       time.Duration
+	adaptiveRetry  *adaptiveRetry
+	framing        Framing
+	selectFraming  func(r *http.Request) Framing // see WithFramingSelector
+	dedup          *dedup
+	marshal        func(v interface{}) ([]byte, error) // nil for json.Marshal
+	autoEventType  func(v interface{}) string
+	origins        []string
+	allowHTTP10    bool
+	validateRaw    bool
+	traceHeader    string
+	traceEcho      string
+	serverTiming   bool
+
+	padding           bool
+	minChunk          int
+	maxAge            time.Duration
+	idleTimeout       time.Duration
+	readDeadline      time.Duration
+	heartbeat         time.Duration
+	heartbeatHTTP2    time.Duration
+	heartbeatHTTP2Set bool
+	heartbeatIdle     bool
+	profiler          func(r *http.Request) ClientProfile
+	observer          Observer
+	sendObserver      func(e Event, clients int)
+	onClientGone      func(clientID string, r *http.Request, cause GoneCause)
+	overflow          OverflowPolicy
+	maxQueuedBytes    int64
+	reconnectGrace    time.Duration
+
+	err error // the first invalid option, see New
 }
 
-// New returns a new initialized SSE Streamer
-func New() *Streamer {
+// New returns a new initialized SSE Streamer.
+// If an option has an invalid value or depends on another option which is not
+// given, e.g. WithTopicRetention without WithTopicParam, New returns an error
+// describing the first such option.
+func New(opts ...Option) (*Streamer, error) {
 	s := &Streamer{
-		event:         make(chan []byte, 1),
-		clients:       make(map[client]bool),
-		connecting:    make(chan client),
-		disconnecting: make(chan client),
+		clients:       make(map[*client]bool),
+		disconnecting: make(chan *client),
+		queries:       make(chan func()),
+		pings:         make(chan struct{}),
+		retained:      make(map[string]message),
+		groups:        make(map[string]map[*client]bool),
+		conns:         make(map[string]int),
+		sessions:      make(map[string]*client),
+		requests:      make(map[*http.Request]*client),
+		held:          make(map[string]*heldClient),
+		closed:        make(chan struct{}),
+		stopped:       make(chan struct{}),
 		bufSize:       2,
+		queueSize:     1,
+		contentType:   "text/event-stream",
+		lineEnding:    "\n",
+		flushEvents:   1,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.err == nil {
+		s.err = s.validate()
+	}
+	if s.err != nil {
+		return nil, s.err
 	}
+	s.event = make(chan message, s.queueSize)
+	if s.selectFraming != nil {
+		// Any client may be served the alternative framing
+		s.framing = FramingJSONLines
+	}
+	if s.shardCount > 1 {
+		s.runShards()
+	}
+	s.keepData = s.history != nil || s.framing != FramingSSE || s.sendObserver != nil
+
+	if !s.direct {
+		s.run()
+	}
+	if s.timeSync > 0 {
+		s.runTimeSync()
+	}
+	return s, nil
+}
 
-	s.run()
+// MustNew is like New but panics if the options are invalid. It simplifies
+// the initialization of global variables holding a Streamer.
+func MustNew(opts ...Option) *Streamer {
+	s, err := New(opts...)
+	if err != nil {
+		panic(err)
+	}
 	return s
 }
 
@@ -48,216 +261,1944 @@ func (s *Streamer) run() {
 	go func() {
 		for {
 			select {
-			case cl := <-s.connecting:
-				s.clients[cl] = true
-
 			case cl := <-s.disconnecting:
-				delete(s.clients, cl)
-
-			case event := <-s.event:
-				for cl := range s.clients {
-					// TODO: non-blocking broadcast
-					//select {
-					//case cl <- event: // Try to send event to client
-					//default:
-					//	fmt.Println("Channel full. Discarding value")
-					//}
-					cl <- event
+				if s.clients[cl] {
+					s.remove(cl)
+					s.holdSlot(cl)
 				}
+
+			case m := <-s.event:
+				s.dispatch(m)
+
+			case query := <-s.queries:
+				query()
+
+			case <-s.pings:
+			}
+
+			if s.closing && len(s.clients) == 0 {
+				return
 			}
 		}
 	}()
 }
 
-// BufSize sets the event buffer size for new clients.
-func (s *Streamer) BufSize(size uint) {
-	s.bufSize = size
+// query runs f in the run goroutine and waits until it returns.
+// This allows safe access to the state owned by the run goroutine.
+// In direct broadcast mode, f is run while holding the lock instead.
+func (s *Streamer) query(f func()) {
+	if s.direct {
+		s.mu.Lock()
+		f()
+		s.mu.Unlock()
+		return
+	}
+
+	done := make(chan struct{})
+	q := func() {
+		f()
+		close(done)
+	}
+	select {
+	case s.queries <- q:
+		<-done
+	case <-s.stopped:
+		// the run goroutine has terminated
+	}
 }
 
-func format(id, event string, dataLen int) (p []byte) {
-	// calc length
-	l := 6 // data\n\n
-	if len(event) > 0 {
-		l += 6 + len(event) + 1 // event:{event}\n
+// Close closes the Streamer. All connected clients are sent their buffered
+// events and then disconnected. Close waits until all clients are
+// disconnected, after which the run goroutine terminates.
+// Events sent after Close are discarded and new clients are rejected.
+func (s *Streamer) Close() {
+	s.close(nil)
+}
+
+// CloseWithEvent is like Close, but sends a final event to all connected
+// clients before they are disconnected, e.g. to tell them to reconnect to
+// another server. The event is delivered after all previously sent events.
+func (s *Streamer) CloseWithEvent(e Event) {
+	s.close(&e)
+}
+
+func (s *Streamer) close(final *Event) {
+	s.query(func() {
+		if s.closing {
+			return
+		}
+		s.closing = true
+
+		// Dispatch all queued events first
+		s.dispatchQueued()
+
+		if final != nil {
+			p := s.formatBytes(final.ID, final.Event, final.Data)
+			if p != nil {
+				s.dispatch(s.newMessage(final.ID, final.Event, p, func() []byte {
+					return final.Data
+				}))
+			}
+		}
+		close(s.closed)
+		s.stop()
+	})
+	<-s.stopped
+}
+
+// dispatchQueued dispatches all events waiting in the event queue.
+// It must be called from the run goroutine.
+func (s *Streamer) dispatchQueued() {
+	if s.direct {
+		return
 	}
-	if dataLen > 0 {
-		l += 1 + dataLen // :{data}
+	for {
+		select {
+		case m := <-s.event:
+			s.dispatch(m)
+		default:
+			return
+		}
 	}
+}
 
-	// build
-	p = make([]byte, l)
-	i := 0
-	if len(event) > 0 {
-		copy(p, "event:")
-		i += 6 + copy(p[6:], event)
-		p[i] = '\n'
-		i++
+// stop closes the stopped channel once the Streamer is closed and all clients
+// are disconnected.
+func (s *Streamer) stop() {
+	if !s.closing || len(s.clients) > 0 {
+		return
 	}
-	i += copy(p[i:], "data")
-	if dataLen > 0 {
-		p[i] = ':'
-		i += 1 + dataLen
+	select {
+	case <-s.stopped:
+	default:
+		close(s.stopped)
+		s.stopShards()
 	}
-	copy(p[i:], "\n\n")
+}
+
+// newClient returns a new client with the current buffer size.
+func (s *Streamer) newClient() *client {
+	bufSize := atomic.LoadUint64(&s.bufSize)
+	return &client{
+		ch:      make(chan message, bufSize),
+		prio:    make(chan message, bufSize),
+		wake:    make(chan struct{}, 1),
+		evicted: make(chan struct{}),
+		gone:    make(chan struct{}),
+	}
+}
+
+// connect registers a new client. It returns the events which must be
+// written to the client before any other event.
+// Events since lastID are replayed from the history, if any.
+// It returns false if the client exceeds the connection limit of its key, see
+// WithMaxConnectionsPerClient.
+func (s *Streamer) connect(cl *client, lastID string) (initial [][]byte, ok bool) {
+	ok = true
+	s.query(func() {
+		if s.closing {
+			return
+		}
+		if cl.limitKey != "" && s.conns[cl.limitKey] >= s.limitConns {
+			ok = false
+			return
+		}
+		s.replaceSession(cl)
+		s.unsubscribeDrained(cl)
+		cl.since = time.Now()
+		s.add(cl)
+
+		// Replay retained events ordered by their type
+		types := make([]string, 0, len(s.retained))
+		for eventType := range s.retained {
+			types = append(types, eventType)
+		}
+		sort.Strings(types)
+		for _, eventType := range types {
+			m := s.retained[eventType]
+			if p := m.bytes(cl.framing); p != nil {
+				initial = append(initial, p)
+			}
+		}
+
+		// Replay the retained events of the subscribed topics
+		initial = append(initial, s.retainedTopics(cl)...)
 
-	// TODO: id
+		// Resume a client which went away within the grace period
+		replay := s.history != nil && lastID != ""
+		initial = append(initial, s.resumeHeld(cl, replay)...)
 
+		// Replay missed events. Errors, e.g. for an unknown ID, are ignored,
+		// since the client can not be informed about it anyway.
+		if s.history != nil && lastID != "" {
+			events, _ := s.history.Since(lastID)
+			for _, e := range events {
+				if p := s.frame(cl.framing, e); p != nil {
+					initial = append(initial, p)
+				}
+			}
+		}
+	})
 	return
 }
 
-// SendBytes sends an event with the given byte slice interpreted as a string
-// as the data value to all connected clients.
-// If the id or event string is empty, no id / event type is send.
-func (s *Streamer) SendBytes(id, event string, data []byte) {
-	dataLen := len(data)
-	lfCount := 0
+// disconnect unregisters a client. It is safe to call disconnect multiple
+// times, also concurrently; the client is unregistered only once.
+func (s *Streamer) disconnect(cl *client) {
+	cl.disconnectOnce.Do(func() {
+		drained := s.unregister(cl)
+		s.hold(cl, drained)
+		s.discardBuffered(cl)
+		s.releaseAll(cl)
+		close(cl.gone)
+	})
+}
 
-	// We must sent a "data:{data}\n" for each line
-	if dataLen > 0 {
-		lfCount = bytes.Count(data, []byte("\n"))
-		if lfCount > 0 {
-			dataLen += (5 * lfCount) // data:
+// discardBuffered discards all events buffered for an unregistered client.
+func (s *Streamer) discardBuffered(cl *client) {
+	for {
+		select {
+		case m := <-cl.ch:
+			m.result.reply(cl, ErrClientGone)
+		case m := <-cl.prio:
+			m.result.reply(cl, ErrClientGone)
+		default:
+			return
 		}
 	}
+}
 
-	p := format(id, event, dataLen)
+// unregister unregisters a client.
+// Until the client is unregistered, its buffers are drained, since the
+// broadcast may be blocked on a full buffer of the client. The drained events
+// are discarded, unless they are kept for a reconnect, see WithReconnectGrace,
+// in which case they are returned.
+func (s *Streamer) unregister(cl *client) (drained []message) {
+	keep := s.holds(cl)
+	drain := func(m message) {
+		if keep {
+			drained = append(drained, m)
+			return
+		}
+		m.result.reply(cl, ErrClientGone)
+	}
 
-	// fill in data lines
-	start := 0
-	ins := len(p) - (2 + dataLen)
-	for i := 0; lfCount > 0; i++ {
-		if data[i] == '\n' {
-			copy(p[ins:], data[start:i])
-			ins += i - start
-			copy(p[ins:], "\ndata:")
-			ins += 6
+	if s.direct {
+		done := make(chan struct{})
+		exited := make(chan struct{})
+		go func() {
+			defer close(exited)
+			for {
+				select {
+				case m := <-cl.ch:
+					drain(m)
+				case m := <-cl.prio:
+					drain(m)
+				case <-done:
+					return
+				}
+			}
+		}()
 
-			start = i + 1
-			lfCount--
+		s.mu.Lock()
+		if s.clients[cl] {
+			s.remove(cl)
+			s.holdSlot(cl)
 		}
+		s.mu.Unlock()
+		close(done)
+		<-exited
+		return
 	}
-	copy(p[ins:], data[start:])
 
-	s.event <- p
+	for {
+		select {
+		case s.disconnecting <- cl:
+			return
+		case <-s.stopped:
+			return
+		case m := <-cl.ch:
+			drain(m)
+		case m := <-cl.prio:
+			drain(m)
+		}
+	}
 }
 
-// SendInt sends an event with the given int as the data value to all connected
-// clients.
-// If the id or event string is empty, no id / event type is send.
-func (s *Streamer) SendInt(id, event string, data int64) {
-	const maxIntToStrLen = 20 // '-' + 19 digits
+// writeBuffered writes all events currently buffered for the client, high
+// priority events first.
+func (s *Streamer) writeBuffered(cl *client, write func(event []byte) error) {
+	for {
+		var m message
+		select {
+		case m = <-cl.prio:
+		default:
+			select {
+			case m = <-cl.prio:
+			case m = <-cl.ch:
+			default:
+				return
+			}
+		}
+		p := s.take(cl, m)
+		if p == nil {
+			m.result.reply(cl, nil)
+			continue
+		}
+		err := write(p)
+		m.result.reply(cl, err)
+		if err != nil {
+			return
+		}
+	}
+}
 
-	p := format(id, event, maxIntToStrLen)
-	p = strconv.AppendInt(p[:len(p)-(maxIntToStrLen+2)], data, 10)
+// take returns the serialized event of a message received from the buffers of
+// the client, or nil if the event expired, see Event.TTL.
+func (s *Streamer) take(cl *client, m message) []byte {
+	p := m.bytes(cl.framing)
+	s.release(cl, int64(len(p)))
+	if !m.expires.IsZero() && time.Now().After(m.expires) {
+		return nil
+	}
+	return p
+}
 
-	// Re-add \n\n at the end
-	p = p[:len(p)+2]
-	p[len(p)-2] = '\n'
-	p[len(p)-1] = '\n'
+// add adds a client to the state of the run goroutine.
+func (s *Streamer) add(cl *client) {
+	s.clients[cl] = true
+	atomic.StoreInt64(&s.clientCount, int64(len(s.clients)))
+	if cl.limitKey != "" {
+		s.conns[cl.limitKey]++
+	}
+	if cl.session != "" {
+		s.sessions[cl.session] = cl
+	}
+	s.addToShard(cl)
 
-	s.event <- p
+	if cl.group != "" {
+		group := s.groups[cl.group]
+		if group == nil {
+			group = make(map[*client]bool)
+			s.groups[cl.group] = group
+		}
+		group[cl] = true
+	}
 }
 
-// SendJSON sends an event with the given data encoded as JSON to all connected
-// clients.
-// If the id or event string is empty, no id / event type is send.
-func (s *Streamer) SendJSON(id, event string, v interface{}) error {
-	data, err := json.Marshal(v)
-	if err != nil {
-		return err
+// remove removes a client from the state of the run goroutine.
+// Removing a client which is not registered has no effect.
+func (s *Streamer) remove(cl *client) {
+	if !s.clients[cl] {
+		return
+	}
+	delete(s.clients, cl)
+	atomic.StoreInt64(&s.clientCount, int64(len(s.clients)))
+	s.stop()
+
+	if cl.limitKey != "" {
+		if s.conns[cl.limitKey]--; s.conns[cl.limitKey] <= 0 {
+			delete(s.conns, cl.limitKey)
+		}
+	}
+	if cl.session != "" && s.sessions[cl.session] == cl {
+		delete(s.sessions, cl.session)
+	}
+	s.removeFromShard(cl)
+
+	if group := s.groups[cl.group]; group != nil {
+		delete(group, cl)
+		if len(group) == 0 {
+			delete(s.groups, cl.group)
+		}
 	}
-	p := format(id, event, len(data))
-	copy(p[len(p)-(2+len(data)):], data) // fill in data
-	s.event <- p
-	return nil
 }
 
-// SendString sends an event with the given data string to all connected
-// clients.
-// If the id or event string is empty, no id / event type is send.
-func (s *Streamer) SendString(id, event, data string) {
-	dataLen := len(data)
-	lfCount := 0
+// newMessage returns a message for the serialized event p. The data of the
+// event is only kept if it is needed, e.g. for the history.
+func (s *Streamer) newMessage(id, event string, p []byte, data func() []byte) message {
+	m := message{
+		event: p,
+		e:     Event{ID: id, Event: event},
+	}
+	if s.keepData || (s.route != nil && p != nil) {
+		m.e.Data = data()
+	}
+	if s.route != nil && p != nil {
+		m.event = s.routed(m.e, p)
+		if !s.keepData {
+			m.e.Data = nil
+		}
+	}
+	if s.framing != FramingSSE && p != nil {
+		m.frame = s.frameJSON(m.e)
+	}
+	return m
+}
 
-	// We must sent a "data:{data}\n" for each line
-	if dataLen > 0 {
-		lfCount = strings.Count(data, "\n")
-		if lfCount > 0 {
-			dataLen += (5 * lfCount) // data:
+// send passes a message to the run goroutine, which sends it to all connected
+// clients. In direct broadcast mode, the message is sent to the clients
+// synchronously instead.
+func (s *Streamer) send(m message) {
+	if m.event == nil {
+		return // rejected, see WithMaxLineLength
+	}
+	if s.direct {
+		s.mu.Lock()
+		if !s.closing {
+			s.dispatch(m)
 		}
+		s.mu.Unlock()
+		return
+	}
+	select {
+	case s.event <- m:
+	case <-s.closed:
 	}
+}
 
-	p := format(id, event, dataLen)
+// dispatch processes a message in the run goroutine and sends it to all
+// connected clients it is addressed to.
+func (s *Streamer) dispatch(m message) {
+	m.result.init(len(s.clients))
+	defer m.result.done()
 
-	// fill in data lines
-	start := 0
-	ins := len(p) - (2 + dataLen)
-	for i := 0; lfCount > 0; i++ {
-		if data[i] == '\n' {
-			copy(p[ins:], data[start:i])
-			ins += i - start
-			copy(p[ins:], "\ndata:")
-			ins += 6
+	if s.dedup != nil && m.e.ID != "" && s.dedup.seen(m.e.ID) {
+		if m.result != nil {
+			for cl := range s.clients {
+				m.result.dropped(cl, ErrDuplicate)
+			}
+		}
+		return
+	}
+	if s.autoID && m.e.ID == "" && !m.hint && !m.batched {
+		s.assignID(&m)
+	}
+	if m.retain {
+		s.retained[m.e.Event] = m
+	}
+	if m.topic != "" && s.topicRetained != nil {
+		s.topicRetained[m.topic] = m
+	}
+	if s.history != nil && m.group == "" && m.header == "" && m.topic == "" && m.to == nil && !m.hint {
+		if m.batch != nil {
+			for _, e := range m.batch {
+				s.history.Append(e)
+			}
+		} else {
+			s.history.Append(m.e)
+		}
+	}
 
-			start = i + 1
-			lfCount--
+	if m.to != nil {
+		if s.clients[m.to] {
+			s.observeSend(&m, 1)
+			s.deliver(m.to, m)
+		} else {
+			s.observeSend(&m, 0)
 		}
+		return
 	}
-	copy(p[ins:], data[start:])
 
-	s.event <- p
+	clients := s.clients
+	if m.group != "" {
+		clients = s.groups[m.group]
+	}
+	var eventType string
+	if s.typeParam != "" && !m.hint && !m.batched {
+		eventType = s.eventType(&m)
+	}
+	if (s.observer != nil || s.sendObserver != nil) && !m.hint {
+		n := 0
+		for cl := range clients {
+			if s.addressed(cl, &m, eventType) {
+				n++
+			}
+		}
+		if s.observer != nil {
+			s.observer.Broadcast(n)
+		}
+		s.observeSend(&m, n)
+	}
+	if len(s.held) > 0 {
+		s.holdEvent(&m, eventType)
+	}
+	if s.shards != nil && m.group == "" && s.maxQueuedBytes == 0 {
+		s.broadcastShards(&m, eventType)
+		return
+	}
+	for cl := range clients {
+		if s.addressed(cl, &m, eventType) {
+			s.deliver(cl, m)
+		}
+	}
 }
 
-// SendUint sends an event with the given unsigned int as the data value to all
-// connected clients.
-// If the id or event string is empty, no id / event type is send.
-func (s *Streamer) SendUint(id, event string, data uint64) {
-	const maxUintToStrLen = 20
+// addressed reports whether the message is addressed to the client. The
+// event type is only set if the clients may filter event types.
+func (s *Streamer) addressed(cl *client, m *message, eventType string) bool {
+	if eventType != "" && cl.types != nil && !cl.types[eventType] {
+		return false
+	}
+	if m.except != "" && cl.id == m.except {
+		return false
+	}
+	if m.topic != "" && !cl.subs.match(m.topic) {
+		return false
+	}
+	if m.header != "" {
+		if value, ok := cl.headers[m.header]; !ok || value != m.value {
+			return false
+		}
+	}
+	return true
+}
 
-	p := format(id, event, maxUintToStrLen)
-	p = strconv.AppendUint(p[:len(p)-(maxUintToStrLen+2)], data, 10)
+// observeSend passes the events of the message to the send observer, see
+// WithSendObserver.
+func (s *Streamer) observeSend(m *message, clients int) {
+	if s.sendObserver == nil || m.hint {
+		return
+	}
+	if m.batched {
+		for _, e := range m.batch {
+			s.sendObserver(e, clients)
+		}
+		return
+	}
+	s.sendObserver(m.e, clients)
+}
 
-	// Re-add \n\n at the end
-	p = p[:len(p)+2]
-	p[len(p)-2] = '\n'
-	p[len(p)-1] = '\n'
+// deliver passes a message to a single client according to the overflow
+// policy.
+func (s *Streamer) deliver(cl *client, m message) {
+	ch := cl.ch
+	if m.prio {
+		ch = cl.prio
+	}
 
-	s.event <- p
-}
+	var size int64
+	if s.maxQueuedBytes > 0 {
+		size = int64(len(m.bytes(cl.framing)))
+		if !s.reserve(cl, size) {
+			if s.clients[cl] {
+				cl.dropped++
+				atomic.AddUint64(&s.dropped, 1)
+				m.result.dropped(cl, ErrBufferFull)
+			} else {
+				m.result.dropped(cl, ErrClientGone) // evicted by reserve
+			}
+			return
+		}
+	}
 
-// ServeHTTP implements http.Handler interface.
-func (s *Streamer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// We need to be able to flush for SSE
-	fl, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Flushing not supported", http.StatusNotImplemented)
+	if s.overflow == OverflowBlock {
+		ch <- m
+		cl.sent++
+		m.result.queued()
 		return
 	}
 
-	// Returns a channel that blocks until the connection is closed
-	close := r.Context().Done()
+	select {
+	case ch <- m:
+		cl.sent++
+		m.result.queued()
+	default:
+		s.release(cl, size)
+		cl.dropped++
+		atomic.AddUint64(&s.dropped, 1)
+		m.result.dropped(cl, ErrBufferFull)
+	}
+}
 
-	// Set headers for SSE
-	h := w.Header()
-	h.Set("Cache-Control", "no-cache")
-	h.Set("Connection", "keep-alive")
-	h.Set("Content-Type", "text/event-stream")
+// ClientCount returns the number of currently connected clients.
+func (s *Streamer) ClientCount() int {
+	return int(atomic.LoadInt64(&s.clientCount))
+}
 
-	// Connect new client
-	cl := make(client, s.bufSize)
-	s.connecting <- cl
+// PauseClient pauses the streams of all clients with the given client ID, see
+// WithClientID, e.g. while the client is not visible. Events for paused
+// clients remain in their buffers until ResumeClient is called. When the
+// buffer of a paused client is full, the overflow policy applies. Note that
+// with OverflowBlock, this blocks the broadcast to all clients, thus
+// OverflowDrop should be used in combination with pausing.
+// Heartbeats are still sent to paused clients.
+func (s *Streamer) PauseClient(clientID string) {
+	s.setPaused(clientID, 1)
+}
 
-	for {
-		select {
-		case <-close:
-			// Disconnect the client when the connection is closed
-			s.disconnecting <- cl
-			return
+// ResumeClient resumes the streams of all clients with the given client ID,
+// see PauseClient. The events buffered while paused are written first.
+func (s *Streamer) ResumeClient(clientID string) {
+	s.setPaused(clientID, 0)
+}
 
-		case event := <-cl:
-			// Write events
-			w.Write(event) // TODO: error handling
-			fl.Flush()
+// DisconnectClient disconnects all clients with the given client ID, see
+// WithClientID, e.g. to end the streams of an abusive user. Their buffered
+// events are discarded. Note that browsers reconnect automatically, thus the
+// client should also be rejected, e.g. with WithAuthorizer.
+// It reports whether any client was disconnected.
+func (s *Streamer) DisconnectClient(clientID string) (disconnected bool) {
+	s.query(func() {
+		for cl := range s.clients {
+			if cl.id == clientID {
+				s.evict(cl)
+				disconnected = true
+			}
+		}
+	})
+	return
+}
+
+func (s *Streamer) setPaused(clientID string, paused int32) {
+	s.query(func() {
+		for cl := range s.clients {
+			if cl.id != clientID {
+				continue
+			}
+			atomic.StoreInt32(&cl.paused, paused)
+			select {
+			case cl.wake <- struct{}{}:
+			default:
+			}
+		}
+	})
+}
+
+// skip reports whether sending an event can be skipped, since no client is
+// connected, see WithSkipWhenEmpty. Events are never skipped if they are
+// recorded in a history.
+func (s *Streamer) skip() bool {
+	return s.skipEmpty && s.history == nil && s.ClientCount() == 0
+}
+
+// BufSize sets the event buffer size for new clients.
+// It is safe to call BufSize while clients are connecting. Already connected
+// clients keep their buffer size.
+func (s *Streamer) BufSize(size uint) {
+	atomic.StoreUint64(&s.bufSize, uint64(size))
+}
+
+// format allocates a serialized event with the given id and event type and
+// room for dataLen bytes of data, which must be filled in by the caller.
+// The data ends before the final two line endings, see dataEnd.
+func (s *Streamer) format(id, event string, dataLen int) (p []byte) {
+	if len(event) > 0 {
+		event = s.prefix + event
+	}
+	le := s.lineEnding
+
+	// calc length
+	l := 4 + 2*len(le) // data{le}{le}
+	if len(id) > 0 {
+		l += 3 + len(id) + len(le) // id:{id}{le}
+	}
+	if len(event) > 0 {
+		l += 6 + len(event) + len(le) // event:{event}{le}
+	}
+	if dataLen > 0 {
+		l += 1 + dataLen // :{data}
+	}
+
+	// build
+	p = make([]byte, l)
+	i := 0
+	if len(id) > 0 {
+		i += copy(p, "id:")
+		i += copy(p[i:], id)
+		i += copy(p[i:], le)
+	}
+	if len(event) > 0 {
+		i += copy(p[i:], "event:")
+		i += copy(p[i:], event)
+		i += copy(p[i:], le)
+	}
+	i += copy(p[i:], "data")
+	if dataLen > 0 {
+		p[i] = ':'
+		i += 1 + dataLen
+	}
+	i += copy(p[i:], le)
+	copy(p[i:], le)
+
+	return
+}
+
+// dataEnd returns the index of the end of the data in the serialized event p,
+// which is followed by two line endings.
+func (s *Streamer) dataEnd(p []byte) int {
+	return len(p) - 2*len(s.lineEnding)
+}
+
+// endEvent appends two line endings to p, which end the event.
+func (s *Streamer) endEvent(p []byte) []byte {
+	p = append(p, s.lineEnding...)
+	return append(p, s.lineEnding...)
+}
+
+// formatBytes serializes an event with the given byte slice as the data value.
+// The data is split into data fields like in formatString.
+func (s *Streamer) formatBytes(id, event string, data []byte) []byte {
+	if s.singleLine && bytes.IndexAny(data, "\\\n\r") >= 0 {
+		data = []byte(dataEscaper.Replace(string(data)))
+	}
+	if s.maxLineLength > 0 && longestLineBytes(data) > s.maxLineLength {
+		if s.linePolicy == LineReject {
+			return nil
+		}
+		data = []byte(splitLines(string(data), s.maxLineLength))
+	}
+	dataLen := len(data)
+	lfCount := 0
+
+	// We must sent a "data:{data}{le}" for each line
+	if dataLen > 0 {
+		lfCount = bytes.Count(data, []byte("\n"))
+		if lfCount > 0 {
+			dataLen += (len(s.lineEnding) + 4) * lfCount // {le}data: instead of \n
+		}
+	}
+
+	p := s.format(id, event, dataLen)
+
+	// fill in data lines
+	start := 0
+	ins := s.dataEnd(p) - dataLen
+	for i := 0; lfCount > 0; i++ {
+		if data[i] == '\n' {
+			ins += copy(p[ins:], data[start:i])
+			ins += copy(p[ins:], s.lineEnding)
+			ins += copy(p[ins:], "data:")
+
+			start = i + 1
+			lfCount--
+		}
+	}
+	copy(p[ins:], data[start:])
+
+	return p
+}
+
+// Send sends an event with the given value as the data to all connected
+// clients. The data is chosen by the type of the value: strings and byte
+// slices are sent as is, like with SendString and SendBytes, integers and
+// floats are sent as decimal numbers and all other values are encoded as JSON,
+// like with SendJSON. Only errors of the JSON encoding are returned.
+// If the id or event string is empty, no id / event type is send, unless the
+// event type is derived from the value, see WithAutoEventType.
+func (s *Streamer) Send(id, event string, v interface{}) error {
+	if event == "" && s.autoEventType != nil {
+		event = s.autoEventType(v)
+	}
+	switch v := v.(type) {
+	case string:
+		if s.maxLineLength > 0 && s.linePolicy == LineReject && longestLine(v) > s.maxLineLength {
+			return ErrLineTooLong
+		}
+		s.SendString(id, event, v)
+	case []byte:
+		if s.maxLineLength > 0 && s.linePolicy == LineReject && longestLineBytes(v) > s.maxLineLength {
+			return ErrLineTooLong
+		}
+		s.SendBytes(id, event, v)
+	case int:
+		s.SendInt(id, event, int64(v))
+	case int8:
+		s.SendInt(id, event, int64(v))
+	case int16:
+		s.SendInt(id, event, int64(v))
+	case int32:
+		s.SendInt(id, event, int64(v))
+	case int64:
+		s.SendInt(id, event, v)
+	case uint:
+		s.SendUint(id, event, uint64(v))
+	case uint8:
+		s.SendUint(id, event, uint64(v))
+	case uint16:
+		s.SendUint(id, event, uint64(v))
+	case uint32:
+		s.SendUint(id, event, uint64(v))
+	case uint64:
+		s.SendUint(id, event, v)
+	case float32:
+		s.SendString(id, event, strconv.FormatFloat(float64(v), 'g', -1, 32))
+	case float64:
+		s.SendString(id, event, strconv.FormatFloat(v, 'g', -1, 64))
+	default:
+		return s.SendJSON(id, event, v)
+	}
+	return nil
+}
+
+// SendBatch sends all given events to all connected clients as a single unit.
+// The events are delivered contiguously and in order, i.e. events sent
+// concurrently by other goroutines are never interleaved with them.
+// The batch expires as a whole with the shortest TTL of its events.
+// If the ID or Event string of an event is empty, no id / event type is send.
+func (s *Streamer) SendBatch(events ...Event) {
+	if len(events) == 0 || s.skip() {
+		return
+	}
+	m := message{batched: true}
+	for _, e := range events {
+		p := s.formatBytes(e.ID, e.Event, e.Data)
+		if p == nil {
+			continue // rejected, see WithMaxLineLength
+		}
+		m.event = append(m.event, s.routed(e, p)...)
+		if s.framing != FramingSSE {
+			m.frame = append(m.frame, s.frameJSON(e)...)
+		}
+		if s.keepData {
+			m.batch = append(m.batch, Event{ID: e.ID, Event: e.Event, Data: append([]byte(nil), e.Data...)})
+		}
+		if expires := expiry(e.TTL); !expires.IsZero() && (m.expires.IsZero() || expires.Before(m.expires)) {
+			m.expires = expires
+		}
+	}
+	s.send(m)
+}
+
+// SendBytes sends an event with the given byte slice interpreted as a string
+// as the data value to all connected clients.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendBytes(id, event string, data []byte) {
+	if s.skip() {
+		return
+	}
+	s.send(s.newMessage(id, event, s.formatBytes(id, event, data), func() []byte {
+		return append([]byte(nil), data...)
+	}))
+}
+
+// SendError sends an event of type "error" describing err to all connected
+// clients. The data is the JSON object {"error":"<message>"} encoded with the
+// configured marshaler, or the plain error message, see WithPlainTextErrors.
+// Note that EventSource clients also dispatch connection errors as "error"
+// events, which however carry no data.
+// If err is nil, no event is sent.
+// If the id string is empty, no id is send.
+func (s *Streamer) SendError(id string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if s.plainErrors {
+		return s.Send(id, "error", err.Error())
+	}
+	return s.SendJSON(id, "error", struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// SendEvent sends the given event to all connected clients.
+// It returns a copy of the serialized event exactly as it is sent to the
+// clients, e.g. for logging or auditing, or nil if the event was rejected, see
+// WithMaxLineLength. As an exception, IDs assigned by WithAutoID are not
+// contained in the copy, since they are only assigned when the event is
+// broadcast, after SendEvent returned.
+func (s *Streamer) SendEvent(e Event) []byte {
+	if s.skip() {
+		return nil
+	}
+	p := s.formatBytes(e.ID, e.Event, e.Data)
+	if p == nil {
+		return nil
+	}
+	m := s.newMessage(e.ID, e.Event, p, func() []byte {
+		return append([]byte(nil), e.Data...)
+	})
+	m.expires = expiry(e.TTL)
+	s.send(m)
+	return append([]byte(nil), m.event...)
+}
+
+// expiry returns the time at which an event with the given TTL expires, or
+// the zero time if it does not expire.
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// SendEventNow sends the given event to all connected clients, like
+// SendEvent, but marks it to be flushed to each client immediately after it
+// was written, regardless of the flush strategy, see WithFlushStrategy.
+func (s *Streamer) SendEventNow(e Event) {
+	if s.skip() {
+		return
+	}
+	m := s.newMessage(e.ID, e.Event, s.formatBytes(e.ID, e.Event, e.Data), func() []byte {
+		return append([]byte(nil), e.Data...)
+	})
+	m.expires = expiry(e.TTL)
+	m.flush = true
+	s.send(m)
+}
+
+// SendInt sends an event with the given int as the data value to all connected
+// clients.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendInt(id, event string, data int64) {
+	if s.skip() {
+		return
+	}
+	s.send(s.newMessage(id, event, s.formatInt(id, event, data), func() []byte {
+		return strconv.AppendInt(nil, data, 10)
+	}))
+}
+
+// formatInt serializes an event with the given int as the data value.
+func (s *Streamer) formatInt(id, event string, data int64) []byte {
+	const maxIntToStrLen = 20 // '-' + 19 digits
+
+	p := s.format(id, event, maxIntToStrLen)
+	p = strconv.AppendInt(p[:s.dataEnd(p)-maxIntToStrLen], data, 10)
+	return s.endEvent(p) // re-add the line endings at the end
+}
+
+// SendJSON sends an event with the given data encoded as JSON to all connected
+// clients. The data is encoded with the configured marshaler, see
+// WithJSONMarshaler.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendJSON(id, event string, v interface{}) error {
+	if s.skip() {
+		return nil
+	}
+	var p, data []byte
+	if s.marshal == nil && s.maxLineLength == 0 && !s.singleLine {
+		// Encode directly into the serialized event, which avoids allocating
+		// the encoded data separately
+		w := jsonEventWriter{s: s, id: id, event: event}
+		if err := json.NewEncoder(&w).Encode(v); err != nil {
+			return err
+		}
+		p, data = w.p, w.data
+	} else {
+		var err error
+		if data, err = s.marshalJSON(v); err != nil {
+			return err
+		}
+		if p = s.formatBytes(id, event, data); p == nil {
+			return ErrLineTooLong
+		}
+	}
+	s.send(s.newMessage(id, event, p, func() []byte {
+		return data
+	}))
+	return nil
+}
+
+// jsonEventWriter serializes an event with the JSON written by a single call
+// of json.Encoder.Encode as the data.
+type jsonEventWriter struct {
+	s         *Streamer
+	id, event string
+	p         []byte // the serialized event
+	data      []byte // the data within p
+}
+
+func (w *jsonEventWriter) Write(b []byte) (int, error) {
+	data := bytes.TrimSuffix(b, []byte("\n")) // added by the encoder
+	w.p = w.s.format(w.id, w.event, len(data))
+	end := w.s.dataEnd(w.p)
+	w.data = w.p[end-len(data) : end]
+	copy(w.data, data)
+	return len(b), nil
+}
+
+// marshalJSON encodes v as JSON with the configured marshaler.
+func (s *Streamer) marshalJSON(v interface{}) ([]byte, error) {
+	if s.marshal == nil {
+		return json.Marshal(v)
+	}
+	return s.marshal(v)
+}
+
+// SendJSONStream sends each item received from items as a separate event
+// encoded as JSON, like SendJSON, until items is closed. This lets large
+// collections be streamed without encoding them as a single array.
+// It stops at the first item which can not be sent and returns the error.
+// If the Streamer is closed, SendJSONStream returns nil without receiving the
+// remaining items, thus producers should not block on sending to items
+// indefinitely.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendJSONStream(id, event string, items <-chan interface{}) error {
+	for {
+		select {
+		case v, ok := <-items:
+			if !ok {
+				return nil
+			}
+			if err := s.SendJSON(id, event, v); err != nil {
+				return err
+			}
+		case <-s.closed:
+			return nil
+		}
+	}
+}
+
+// SendLines sends an event with one data field per given line to all
+// connected clients. Clients join the lines with a newline.
+// Unlike SendBytes, the data is not scanned for newlines, thus the lines must
+// not contain any. An empty slice of lines is sent like empty data, as a single
+// "data" field without a value.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendLines(id, event string, lines [][]byte) {
+	if s.skip() {
+		return
+	}
+	s.send(s.newMessage(id, event, s.formatLines(id, event, lines), func() []byte {
+		return bytes.Join(lines, []byte("\n"))
+	}))
+}
+
+// SendDataLines sends an event with one data field per given line to all
+// connected clients, like SendLines.
+//
+// Server-Sent Events can not express multiple separate data values in a
+// single event: clients join all data fields of an event with a newline into
+// a single string. The lines are restored by splitting the data at each
+// newline, see DataLines. This round trip preserves the lines, including
+// empty lines, under these conditions:
+//   - lines must not contain newlines or carriage returns
+//   - lines must not start with a space, which clients strip
+//   - an empty slice of lines can not be distinguished from a single empty
+//     line, both are restored as a single empty line
+//   - the lines must not be split, see LineSplit
+//
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendDataLines(id, event string, lines []string) {
+	if s.skip() {
+		return
+	}
+	p := make([][]byte, len(lines))
+	for i, line := range lines {
+		p[i] = []byte(line)
+	}
+	s.SendLines(id, event, p)
+}
+
+// formatLines serializes an event with one data field per line.
+func (s *Streamer) formatLines(id, event string, lines [][]byte) []byte {
+	if s.maxLineLength > 0 {
+		var split [][]byte
+		for _, line := range lines {
+			if len(line) <= s.maxLineLength {
+				split = append(split, line)
+				continue
+			}
+			if s.linePolicy == LineReject {
+				return nil
+			}
+			for len(line) > s.maxLineLength {
+				split = append(split, line[:s.maxLineLength])
+				line = line[s.maxLineLength:]
+			}
+			split = append(split, line)
+		}
+		lines = split
+	}
+	dataLen := 0
+	for i, line := range lines {
+		if i > 0 {
+			dataLen += len(s.lineEnding) + 5 // {le}data:
+		}
+		dataLen += len(line)
+	}
+
+	p := s.format(id, event, dataLen)
+
+	// fill in data lines
+	ins := s.dataEnd(p) - dataLen
+	for i, line := range lines {
+		if i > 0 {
+			ins += copy(p[ins:], s.lineEnding)
+			ins += copy(p[ins:], "data:")
+		}
+		ins += copy(p[ins:], line)
+	}
+
+	return p
+}
+
+// PatchSuffix is appended to the event type of events sent with SendPatch.
+const PatchSuffix = ":patch"
+
+// SendPatch sends an event with the given JSON Merge Patch (RFC 7396) encoded
+// as JSON to all connected clients, like SendJSON. The event type is the given
+// event type with PatchSuffix appended, e.g. "state:patch" for "state", or
+// "message:patch" if the event type is empty.
+//
+// This lets clients distinguish patches from full values: a client receiving
+// an event of the type with the suffix must apply the data as a merge patch to
+// the last value it holds for the event type without the suffix, i.e. it
+// recursively merges objects and removes members whose value is null. Any
+// other value, like an array, replaces the held value. A client which does not
+// hold a value yet must treat the patch as if applied to an empty object.
+// Since patches build on each other, clients connecting later need the full
+// value first, e.g. sent with SendRetained.
+// If the id string is empty, no id is send.
+func (s *Streamer) SendPatch(id, event string, patch interface{}) error {
+	if event == "" {
+		event = "message"
+	}
+	return s.SendJSON(id, event+PatchSuffix, patch)
+}
+
+// SendRetained sends an event with the given byte slice as the data value to
+// all connected clients, like SendBytes.
+// Additionally, the event is retained as the latest event of its event type
+// and sent to every client connecting later, ordered by the event type,
+// before any other event. This gives late joiners the current state.
+// Each retained event replaces the previously retained event of the same type.
+func (s *Streamer) SendRetained(id, event string, data []byte) {
+	m := s.newMessage(id, event, s.formatBytes(id, event, data), func() []byte {
+		return append([]byte(nil), data...)
+	})
+	m.retain = true
+	s.send(m)
+}
+
+// formatString serializes an event with the given data string.
+//
+// Each line of the data is sent in its own data field. A trailing newline
+// thus results in a final empty data field, e.g. "foo\n" is sent as
+// "data:foo\ndata:\n\n" and "\n" as "data:\ndata:\n\n". Clients join the
+// values of all data fields with a newline, which restores the original data
+// exactly. Empty data is sent as a single "data" field without a value.
+func (s *Streamer) formatString(id, event, data string) []byte {
+	if s.singleLine {
+		data = dataEscaper.Replace(data)
+	}
+	if s.maxLineLength > 0 && longestLine(data) > s.maxLineLength {
+		if s.linePolicy == LineReject {
+			return nil
+		}
+		data = splitLines(data, s.maxLineLength)
+	}
+	dataLen := len(data)
+	lfCount := 0
+
+	// We must sent a "data:{data}{le}" for each line
+	if dataLen > 0 {
+		lfCount = strings.Count(data, "\n")
+		if lfCount > 0 {
+			dataLen += (len(s.lineEnding) + 4) * lfCount // {le}data: instead of \n
+		}
+	}
+
+	p := s.format(id, event, dataLen)
+
+	// fill in data lines
+	start := 0
+	ins := s.dataEnd(p) - dataLen
+	for i := 0; lfCount > 0; i++ {
+		if data[i] == '\n' {
+			ins += copy(p[ins:], data[start:i])
+			ins += copy(p[ins:], s.lineEnding)
+			ins += copy(p[ins:], "data:")
+
+			start = i + 1
+			lfCount--
+		}
+	}
+	copy(p[ins:], data[start:])
+
+	return p
+}
+
+// SendString sends an event with the given data string to all connected
+// clients.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendString(id, event, data string) {
+	if s.skip() {
+		return
+	}
+	s.send(s.newMessage(id, event, s.formatString(id, event, data), func() []byte {
+		return []byte(data)
+	}))
+}
+
+// SendStringCtx sends an event with the given data string to all connected
+// clients, like SendString. If ctx is done before the event could be queued for
+// broadcasting, e.g. because the broadcast is blocked by slow clients, the
+// event is abandoned and ctx.Err() is returned.
+// In direct broadcast mode, ctx is only checked before the event is sent.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendStringCtx(ctx context.Context, id, event, data string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.skip() {
+		return nil
+	}
+	p := s.formatString(id, event, data)
+	if p == nil {
+		return ErrLineTooLong
+	}
+	m := s.newMessage(id, event, p, func() []byte {
+		return []byte(data)
+	})
+	if s.direct {
+		s.send(m)
+		return nil
+	}
+	select {
+	case s.event <- m:
+	case <-s.closed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// SendStringExcept sends an event with the given data string to all connected
+// clients except those with the given client ID, see WithClientID. This is
+// useful to not echo an event back to the client which caused it.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendStringExcept(excludeClientID string, id, event, data string) {
+	if s.skip() {
+		return
+	}
+	m := s.newMessage(id, event, s.formatString(id, event, data), func() []byte {
+		return []byte(data)
+	})
+	m.except = excludeClientID
+	s.send(m)
+}
+
+// SendStringPriority sends an event with the given data string to all
+// connected clients, like SendString.
+// If prio is greater than 0, the event is a high priority event, which is
+// written to each client before all of its queued normal events. High
+// priority events are queued separately and are delivered among each other in
+// FIFO order. Note that a steady stream of high priority events starves the
+// normal events of a client.
+// If prio is 0 or less, the event is sent like with SendString.
+func (s *Streamer) SendStringPriority(prio int, id, event, data string) {
+	if s.skip() {
+		return
+	}
+	m := s.newMessage(id, event, s.formatString(id, event, data), func() []byte {
+		return []byte(data)
+	})
+	m.prio = prio > 0
+	s.send(m)
+}
+
+// SendStringToHeader sends an event with the given data string to all
+// connected clients whose request had the given header with the given value,
+// e.g. "X-Tenant: acme". The header must be indexed, see WithIndexedHeaders.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendStringToHeader(header, value string, id, event, data string) {
+	if header == "" || s.skip() {
+		return
+	}
+	m := s.newMessage(id, event, s.formatString(id, event, data), func() []byte {
+		return []byte(data)
+	})
+	m.header = http.CanonicalHeaderKey(header)
+	m.value = value
+	s.send(m)
+}
+
+// SendToGroup sends the given event only to the connected clients of the
+// given group, see WithGroupKey. If the group has no connected clients, the
+// event is discarded.
+func (s *Streamer) SendToGroup(group string, e Event) {
+	if group == "" || s.skip() {
+		return
+	}
+	m := s.newMessage(e.ID, e.Event, s.formatBytes(e.ID, e.Event, e.Data), func() []byte {
+		return append([]byte(nil), e.Data...)
+	})
+	m.group = group
+	s.send(m)
+}
+
+// SendUint sends an event with the given unsigned int as the data value to all
+// connected clients.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendUint(id, event string, data uint64) {
+	if s.skip() {
+		return
+	}
+	const maxUintToStrLen = 20
+
+	p := s.format(id, event, maxUintToStrLen)
+	p = strconv.AppendUint(p[:s.dataEnd(p)-maxUintToStrLen], data, 10)
+	p = s.endEvent(p) // re-add the line endings at the end
+
+	s.send(s.newMessage(id, event, p, func() []byte {
+		return strconv.AppendUint(nil, data, 10)
+	}))
+}
+
+// Ping sends an empty comment to all connected clients, like the heartbeat of
+// WithHeartbeat, but on demand, e.g. right after finishing a batch of work to
+// keep proxies from closing the connections. Clients ignore comments, thus
+// the ping is not an event; it is neither recorded in the history nor passed
+// to the send observer. Clients of FramingJSONLines receive an empty line.
+func (s *Streamer) Ping() {
+	if s.skip() {
+		return
+	}
+	s.send(message{
+		event: s.withLineEnding(heartbeatComment),
+		frame: []byte("\n"),
+		hint:  true,
+		flush: true,
+	})
+}
+
+// Pump reads events from an upstream event stream r and sends each of them to
+// all connected clients, e.g. to fan out a single upstream source to many
+// clients.
+// Pump returns when the end of r is reached or ctx is done. Since reads from r
+// can not be interrupted, the context is only checked between events.
+// Errors while reading r are returned.
+func (s *Streamer) Pump(ctx context.Context, r io.Reader) error {
+	dec := NewDecoder(r)
+	for {
+		e, err := dec.Decode()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		s.SendEvent(e)
+	}
+}
+
+// ServeSSE returns a handler which streams all events received from events
+// to all connected clients. It is a shortcut for the simple case of a single
+// source of events without further configuration.
+// When events is closed, the underlying Streamer is closed, which disconnects
+// all clients and rejects new ones.
+func ServeSSE(events <-chan Event) http.Handler {
+	s := MustNew()
+	go func() {
+		for e := range events {
+			s.SendEvent(e)
+		}
+		s.Close()
+	}()
+	return s
+}
+
+// heartbeatComment is an empty comment, which is ignored by clients.
+var heartbeatComment = []byte(":\n\n")
+
+// paddingComment is a comment of 2 KiB, which defeats the buffering of some
+// browsers and intermediaries.
+var paddingComment = []byte(":" + strings.Repeat(" ", 2046) + "\n")
+
+// withLineEnding returns p with all LF line endings replaced by the
+// configured line ending.
+func (s *Streamer) withLineEnding(p []byte) []byte {
+	if s.lineEnding == "\n" {
+		return p
+	}
+	return bytes.Replace(p, []byte("\n"), []byte(s.lineEnding), -1)
+}
+
+// clientProfile returns the profile for the client of the given request.
+func (s *Streamer) clientProfile(r *http.Request) ClientProfile {
+	if s.profiler != nil {
+		return s.profiler(r)
+	}
+
+	profile := ClientProfile{
+		Padding:   s.padding,
+		Heartbeat: s.heartbeat,
+		Retry:     time.Duration(atomic.LoadInt64(&s.retry)),
+	}
+	if r.ProtoMajor == 2 && s.heartbeatHTTP2Set {
+		profile.Heartbeat = s.heartbeatHTTP2
+	}
+	return profile
+}
+
+// AddWriter registers w as a client, which receives the same events as the
+// HTTP clients, e.g. to log all events to a file or to bridge them to another
+// transport. The events are written in the Server-Sent Events format.
+// The writer is removed when writing to it fails or when the returned function
+// is called, which waits until no further event is written to w.
+func (s *Streamer) AddWriter(w io.Writer) (remove func()) {
+	cl := s.newClient()
+	initial, _ := s.connect(cl, "")
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer s.disconnect(cl)
+		s.writeEvents(cl, initial, func(event []byte) error {
+			return writeFull(w, event)
+		}, stop, nil)
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// Subscribe registers an in-process subscriber, which receives the same events
+// as the HTTP clients decoded on the returned channel, e.g. for tests or
+// consumers within the same process. Events are decoded like by a Decoder,
+// thus events without data are not received.
+// The channel is closed when ctx is done, the returned function is called or
+// the Streamer is closed. The function waits until the subscriber is removed.
+// Like any client, the subscriber must keep receiving the events, otherwise
+// its buffer becomes full, see WithOverflowPolicy.
+func (s *Streamer) Subscribe(ctx context.Context) (<-chan Event, func()) {
+	pr, pw := io.Pipe()
+	cl := s.newClient()
+	initial, _ := s.connect(cl, "")
+
+	stop := make(chan struct{})
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		defer pw.Close()
+		defer s.disconnect(cl)
+		s.writeEvents(cl, initial, func(event []byte) error {
+			return writeFull(pw, event)
+		}, stop, nil)
+	}()
+
+	events := make(chan Event)
+	decoded := make(chan struct{})
+	go func() {
+		defer close(decoded)
+		defer close(events)
+		d := NewDecoder(pr)
+		for {
+			e, err := d.Decode()
+			if err != nil {
+				return
+			}
+			select {
+			case events <- e:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(stop)
+			pr.Close()
+		})
+		<-written
+		<-decoded
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-decoded:
+		}
+	}()
+	return events, cancel
+}
+
+// writeEvents writes the initial events and then all events of the client
+// until writing fails, stop is closed or the client is disconnected by the
+// server. Each tick of heartbeat writes a heartbeat comment.
+func (s *Streamer) writeEvents(cl *client, initial [][]byte, write func(event []byte) error, stop <-chan struct{}, heartbeat <-chan time.Time) {
+	for _, event := range initial {
+		if write(event) != nil {
+			return
+		}
+	}
+
+	for {
+		var event []byte
+		var result *sendResult
+		select {
+		case <-stop:
+			return
+		case <-s.closed:
+			s.writeBuffered(cl, write)
+			return
+		case <-cl.evicted:
+			if cl.drained {
+				s.writeBuffered(cl, write)
+			}
+			if cl.final != nil {
+				write(cl.final)
+			}
+			return
+		case m := <-cl.prio:
+			event, result = s.take(cl, m), m.result
+		case m := <-cl.ch:
+			event, result = s.take(cl, m), m.result
+		case <-heartbeat:
+			event = s.withLineEnding(heartbeatComment)
+		}
+		var err error
+		if event != nil {
+			err = write(event)
+		}
+		result.reply(cl, err)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler interface.
+func (s *Streamer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, nil)
+}
+
+// ServeHTTPContext serves the stream like ServeHTTP, but additionally ends the
+// stream when ctx is done, e.g. when the Streamer is part of a larger component
+// with its own lifecycle. The stream ends when either ctx or the request
+// context is done.
+func (s *Streamer) ServeHTTPContext(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, ctx.Done())
+}
+
+// serve streams events to a client until the request context or stop is done.
+func (s *Streamer) serve(w http.ResponseWriter, r *http.Request, stop <-chan struct{}) {
+	// The client may have been created by Session already
+	cl := s.takeSessionClient(r)
+
+	if s.traceHeader != "" {
+		r = s.withTraceID(w, r)
+	}
+
+	// We need to be able to flush for SSE
+	fl, ok := w.(http.Flusher)
+	unwrapped := false
+	if !ok && s.bufferingCheck {
+		// Fall back to the flusher of a wrapped writer
+		fl, ok = unwrapFlusher(w)
+		unwrapped = ok
+	}
+	if !ok {
+		http.Error(w, "Flushing not supported", http.StatusNotImplemented)
+		return
+	}
+
+	if r.ProtoMajor == 1 && r.ProtoMinor == 0 && !s.allowHTTP10 {
+		http.Error(w, "HTTP/1.0 not supported", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	if s.origins != nil {
+		if origin := r.Header.Get("Origin"); origin != "" && !originAllowed(s.origins, origin) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+	}
+
+	timing := s.newServerTiming()
+	if s.authorize != nil {
+		ok, status := s.authorize(r)
+		timing.mark("auth")
+		if !ok {
+			if status == 0 {
+				status = http.StatusForbidden
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+
+	select {
+	case <-s.closed:
+		http.Error(w, "Streamer closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	if s.bufferingCheck {
+		checkBuffering(w, r, unwrapped)
+	}
+
+	// Returns a channel that blocks until the connection is closed
+	close := r.Context().Done()
+
+	// Set headers for SSE
+	framing := s.negotiateFraming(r)
+	h := w.Header()
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	if framing == FramingJSONLines {
+		h.Set("Content-Type", JSONLinesContentType)
+	} else {
+		h.Set("Content-Type", s.contentType)
+	}
+
+	// Connect new client
+	cl.framing = framing
+	if s.groupKey != nil {
+		cl.group = s.groupKey(r)
+	}
+	if s.clientID != nil {
+		cl.id = s.clientID(r)
+	}
+	if s.limitKey != nil {
+		cl.limitKey = s.limitKey(r)
+	}
+	if s.sessionKey != nil {
+		cl.session = s.sessionKey(r)
+	}
+	if s.topicParam != "" {
+		cl.subs = parseSubscriptions(r, s.topicParam)
+	}
+	if s.typeParam != "" {
+		cl.types = parseEventTypes(r, s.typeParam)
+	}
+	if len(s.headers) > 0 {
+		cl.headers = make(map[string]string, len(s.headers))
+		for _, header := range s.headers {
+			cl.headers[header] = r.Header.Get(header)
+		}
+	}
+	profile := s.clientProfile(r)
+	if s.adaptiveRetry != nil {
+		profile.Retry = s.adaptiveRetry.next(retryKey(cl, r), time.Now())
+	}
+	timing.skip()
+	initial, ok := s.connect(cl, r.Header.Get("Last-Event-ID"))
+	if !ok {
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
+	timing.mark("connect")
+	defer s.disconnect(cl)
+
+	// gone is set if the client went away, see WithOnClientGone
+	var gone GoneCause
+	if s.onClientGone != nil {
+		defer func() {
+			if gone != 0 {
+				s.onClientGone(cl.id, r, gone)
+			}
+		}()
+	}
+
+	if s.observer != nil {
+		if disconnected := s.observer.Connect(r); disconnected != nil {
+			defer disconnected()
+		}
+	}
+
+	var heartbeatTick <-chan time.Time
+	var heartbeatTimer *time.Timer // reset by every write, see WithIdleHeartbeat
+	heartbeat := s.withLineEnding(heartbeatComment)
+	if framing == FramingJSONLines {
+		heartbeat = []byte("\n")
+	}
+	if interval := profile.Heartbeat; interval > 0 && s.heartbeatIdle {
+		heartbeatTimer = time.NewTimer(interval)
+		defer heartbeatTimer.Stop()
+		heartbeatTick = heartbeatTimer.C
+	} else if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		heartbeatTick = ticker.C
+	}
+
+	// Periodically extend the read deadline, failures indicate a dead
+	// connection
+	var readDeadline <-chan time.Time
+	if s.readDeadline > 0 {
+		interval := s.readDeadline / 2
+		if interval <= 0 {
+			interval = s.readDeadline
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		readDeadline = ticker.C
+	}
+
+	var maxAge <-chan time.Time
+	if s.maxAge > 0 {
+		timer := time.NewTimer(s.maxAge)
+		defer timer.Stop()
+		maxAge = timer.C
+	}
+
+	// The idle timer is reset with every write
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if s.idleTimeout > 0 {
+		idleTimer = time.NewTimer(s.idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	// The flush timer is started by the first unflushed write
+	var flushDeadline <-chan time.Time
+	var flushTimer *time.Timer
+	if s.flushInterval > 0 {
+		flushTimer = time.NewTimer(s.flushInterval)
+		defer flushTimer.Stop()
+	}
+
+	// Write events. The client is disconnected when writing or flushing fails.
+	// Writes are flushed according to the flush strategy, or immediately if
+	// now is set.
+	flush := flushFunc(w, fl)
+	pending := 0 // number of unflushed writes
+	flushPending := func() error {
+		if pending == 0 {
+			return nil
+		}
+		pending = 0
+		flushDeadline = nil
+		return flush()
+	}
+	write := func(event []byte, now bool) error {
+		if len(event) == 0 {
+			// the event has no representation in the client's framing
+			return nil
+		}
+		if s.minChunk > 0 && framing == FramingSSE {
+			event = s.padChunk(event)
+		}
+		if err := writeFull(w, event); err != nil {
+			return err
+		}
+		if idleTimer != nil {
+			resetTimer(idleTimer, s.idleTimeout)
+		}
+		if heartbeatTimer != nil {
+			resetTimer(heartbeatTimer, profile.Heartbeat)
+		}
+
+		pending++
+		if now || (s.flushEvents > 0 && pending >= s.flushEvents) {
+			return flushPending()
+		}
+		if pending == 1 && flushTimer != nil {
+			resetTimer(flushTimer, s.flushInterval)
+			flushDeadline = flushTimer.C
+		}
+		return nil
+	}
+
+	// The time to the first event written to the client is recorded, see
+	// Stats
+	waiting := true
+	firstEvent := func() {
+		if waiting {
+			waiting = false
+			s.recordFirstEvent(time.Since(cl.since))
+		}
+	}
+
+	// The initial events are written in the order documented by
+	// WithConnectPreamble
+	var preamble [][]byte
+	if profile.Padding && framing == FramingSSE {
+		preamble = append(preamble, s.withLineEnding(paddingComment))
+	}
+	if profile.Retry > 0 && framing == FramingSSE {
+		preamble = append(preamble, s.formatRetry(profile.Retry))
+	}
+	controls := len(preamble) // written before the first event
+	for _, e := range s.preamble {
+		if p := s.frame(framing, e); p != nil {
+			preamble = append(preamble, p)
+		}
+	}
+	initial = append(preamble, initial...)
+	if token := r.Header.Get(s.resumeHeader); token != "" && s.resume != nil {
+		timing.skip()
+		for _, e := range s.resume(token) {
+			if p := s.frame(framing, e); p != nil {
+				initial = append(initial, p)
+			}
+		}
+		timing.mark("resume")
+	}
+	if s.backlog != nil {
+		timing.skip()
+		for _, e := range s.backlog(r) {
+			if p := s.frame(framing, e); p != nil {
+				initial = append(initial, p)
+			}
+		}
+		timing.mark("backlog")
+	}
+	if timing != nil {
+		h.Set("Server-Timing", timing.String())
+	}
+	// Write all initial events at once with a single flush
+	if len(initial) > 0 {
+		var buf []byte
+		for _, event := range initial {
+			buf = append(buf, event...)
+		}
+		if write(buf, true) != nil {
+			gone = GoneWriteError
+			return
+		}
+		if len(initial) > controls {
+			firstEvent()
+		}
+	}
+
+	for {
+		var err error
+
+		// Events remain in the buffers while the client is paused
+		events, prio := cl.ch, cl.prio
+		if atomic.LoadInt32(&cl.paused) != 0 {
+			events, prio = nil, nil
+		}
+
+		// High priority events overtake all queued normal events
+		select {
+		case m := <-prio:
+			p := s.take(cl, m)
+			err = write(p, m.flush)
+			m.result.reply(cl, err)
+			if err != nil {
+				gone = GoneWriteError
+				return
+			}
+			if len(p) > 0 {
+				firstEvent()
+			}
+			continue
+		default:
+		}
+
+		select {
+		case <-close:
+			// Disconnect the client when the connection is closed
+			gone = GoneCanceled
+			return
+
+		case <-stop:
+			flushPending()
+			return
+
+		case <-s.closed:
+			// Write the remaining events, e.g. the final event of
+			// CloseWithEvent, before disconnecting
+			s.writeBuffered(cl, func(event []byte) error {
+				return write(event, false)
+			})
+			flushPending()
+			return
+
+		case m := <-prio:
+			p := s.take(cl, m)
+			err = write(p, m.flush)
+			m.result.reply(cl, err)
+			if err == nil && len(p) > 0 {
+				firstEvent()
+			}
+
+		case m := <-events:
+			p := s.take(cl, m)
+			err = write(p, m.flush)
+			m.result.reply(cl, err)
+			if err == nil && len(p) > 0 {
+				firstEvent()
+			}
+
+		case <-cl.evicted:
+			if cl.drained {
+				s.writeBuffered(cl, func(event []byte) error {
+					return write(event, false)
+				})
+			}
+			if cl.final != nil {
+				write(cl.final, true)
+			}
+			flushPending()
+			return
+
+		case <-cl.wake:
+			// the client was paused or resumed
+
+		case <-flushDeadline:
+			err = flushPending()
+
+		case <-heartbeatTick:
+			// Keep the connection alive
+			err = write(heartbeat, true)
+
+		case <-readDeadline:
+			err = setReadDeadline(w, time.Now().Add(s.readDeadline))
+			if err == http.ErrNotSupported {
+				readDeadline = nil
+				err = nil
+			}
+
+		case <-idle:
+			// Close idle connections to let the client reconnect
+			flushPending()
+			return
+
+		case <-maxAge:
+			// Close the connection and let the client reconnect after
+			// its reconnection time, if any
+			if framing == FramingSSE && profile.Retry > 0 {
+				write(s.formatRetry(profile.Retry), true)
+			} else {
+				flushPending()
+			}
+			return
+		}
+
+		if err != nil {
+			gone = GoneWriteError
+			return
+		}
+	}
+}
+
+// writeFull writes all of p to w. Writers may write less than p without
+// returning an error, in which case the rest is written again, since a
+// partially written event would corrupt the stream.
+func writeFull(w io.Writer, p []byte) error {
+	for len(p) > 0 {
+		n, err := w.Write(p)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// resetTimer stops the timer t, drains its channel and resets it to d.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
 		}
 	}
+	t.Reset(d)
 }
@@ -5,10 +5,18 @@
 package sse
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"math"
 	"net/http"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -71,13 +79,13 @@ func NewMockRequestNeverClose() *http.Request {
 	return request
 }
 
-func NewMockRequestWithTimeout(d time.Duration) *http.Request {
+func NewMockRequestWithTimeout(d time.Duration) (*http.Request, context.CancelFunc) {
 	request, err := http.NewRequest("GET", "MOCK", nil)
 	if err != nil {
 		panic(err)
 	}
-	context, _ := context.WithTimeout(context.Background(), d)
-	return request.WithContext(context)
+	context, cancel := context.WithTimeout(context.Background(), d)
+	return request.WithContext(context), cancel
 }
 
 // all those good old Java times...
@@ -91,13 +99,123 @@ func NewMockResponseWriteFlushCloser() *mockResponseWriteFlushCloser {
 	}
 }
 
+// mockChanWriteFlusher passes every write to the writes channel.
+type mockChanWriteFlusher struct {
+	*mockResponseWriter
+	writes chan string
+}
+
+func (m mockChanWriteFlusher) Write(p []byte) (n int, err error) {
+	m.writes <- string(p)
+	return len(p), nil
+}
+
+func (m mockChanWriteFlusher) Flush() {}
+
+func NewMockChanWriteFlusher() mockChanWriteFlusher {
+	return mockChanWriteFlusher{
+		NewMockResponseWriter(),
+		make(chan string, 100),
+	}
+}
+
+// mockBlockingWriteFlusher blocks every write until unblock is closed.
+// writing receives a value when a write starts.
+type mockBlockingWriteFlusher struct {
+	mockChanWriteFlusher
+	writing chan struct{}
+	unblock chan struct{}
+}
+
+func (m mockBlockingWriteFlusher) Write(p []byte) (n int, err error) {
+	select {
+	case m.writing <- struct{}{}:
+	default:
+	}
+	<-m.unblock
+	return m.mockChanWriteFlusher.Write(p)
+}
+
+func NewMockBlockingWriteFlusher() mockBlockingWriteFlusher {
+	return mockBlockingWriteFlusher{
+		NewMockChanWriteFlusher(),
+		make(chan struct{}, 1),
+		make(chan struct{}),
+	}
+}
+
+// mockFlushRecorder passes every write and flush to the writes channel.
+// Flushes are passed as flushMarker.
+type mockFlushRecorder struct {
+	mockChanWriteFlusher
+}
+
+const flushMarker = "<flush>"
+
+func (m mockFlushRecorder) Flush() {
+	m.writes <- flushMarker
+}
+
+func NewMockFlushRecorder() mockFlushRecorder {
+	return mockFlushRecorder{NewMockChanWriteFlusher()}
+}
+
+// recv receives the next value from the channel or fails after a timeout.
+func recv(t *testing.T, c <-chan string) string {
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout while waiting for a write")
+		return ""
+	}
+}
+
+// serve runs the streamer for the given request in a new goroutine and waits
+// until the client is connected. The returned function cancels the request
+// and waits until ServeHTTP returned.
+func serve(t *testing.T, s *Streamer, w http.ResponseWriter, r *http.Request, cancel context.CancelFunc) (stop func()) {
+	n := s.Stats().Clients
+	done := make(chan struct{})
+	go func() {
+		s.ServeHTTP(w, r)
+		close(done)
+	}()
+	waitForClients(t, s, n+1)
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// waitFor polls cond until it returns true or the timeout is reached.
+func waitFor(t *testing.T, cond func() bool) {
+	for deadline := time.Now().Add(2 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout while waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForClients waits until the given number of clients is connected.
+func waitForClients(t *testing.T, s *Streamer, n int) {
+	waitFor(t, func() bool {
+		return s.Stats().Clients == n
+	})
+}
+
 func TestNoFlush(t *testing.T) {
-	streamer := New()
+	streamer := MustNew()
 	w := NewMockResponseWriter()
 
+	r, cancel := NewMockRequestWithTimeout(500 * time.Millisecond)
+	defer cancel()
+
 	time.Sleep(500 * time.Millisecond)
 
-	streamer.ServeHTTP(w, NewMockRequestWithTimeout(500*time.Millisecond))
+	streamer.ServeHTTP(w, r)
 
 	if w.status != http.StatusNotImplemented {
 		t.Fatal("wrong status code:", w.status)
@@ -108,12 +226,15 @@ func TestNoFlush(t *testing.T) {
 }
 
 func TestClose(t *testing.T) {
-	streamer := New()
+	streamer := MustNew()
 	w := NewMockResponseWriteFlusher()
 
+	r, cancel := NewMockRequestWithTimeout(time.Millisecond)
+	defer cancel()
+
 	time.Sleep(500 * time.Millisecond)
 
-	streamer.ServeHTTP(w, NewMockRequestWithTimeout(time.Millisecond))
+	streamer.ServeHTTP(w, r)
 
 	if w.status != http.StatusOK {
 		t.Fatal("wrong status code:", w.status)
@@ -124,27 +245,27 @@ func TestClose(t *testing.T) {
 }
 
 func TestClientConnection(t *testing.T) {
-	streamer := New()
+	streamer := MustNew()
 	w := NewMockResponseWriteFlushCloser()
 	r, cancel := NewMockRequest()
 
 	time.Sleep(500 * time.Millisecond)
 	go func() {
 		time.Sleep(500 * time.Millisecond)
-		if len(streamer.clients) != 1 {
-			t.Fatal("expected 1 client, has:", len(streamer.clients))
+		if n := streamer.Stats().Clients; n != 1 {
+			t.Error("expected 1 client, has:", n)
 		}
 		cancel()
 	}()
 
-	if len(streamer.clients) != 0 {
-		t.Fatal("expected 0 clients, has:", len(streamer.clients))
+	if n := streamer.Stats().Clients; n != 0 {
+		t.Fatal("expected 0 clients, has:", n)
 	}
 	streamer.ServeHTTP(w, r)
 
 	time.Sleep(500 * time.Millisecond)
-	if len(streamer.clients) != 0 {
-		t.Fatal("expected 0 clients, has:", len(streamer.clients))
+	if n := streamer.Stats().Clients; n != 0 {
+		t.Fatal("expected 0 clients, has:", n)
 	}
 
 	if w.status != http.StatusOK {
@@ -152,8 +273,112 @@ func TestClientConnection(t *testing.T) {
 	}
 }
 
+func TestBufSizeRace(t *testing.T) {
+	streamer := MustNew()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var done = make(chan struct{})
+	for i := 0; i < 10; i++ {
+		go func() {
+			r := NewMockRequestNeverClose().WithContext(ctx)
+			streamer.ServeHTTP(NewMockResponseWriteFlushCloser(), r)
+			done <- struct{}{}
+		}()
+		streamer.BufSize(uint(i))
+	}
+
+	waitForClients(t, streamer, 10)
+	cancel()
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+}
+
+type mockWriteErrorFlusher struct {
+	*mockResponseWriter
+}
+
+func (m mockWriteErrorFlusher) Write(p []byte) (n int, err error) {
+	return 0, errors.New("broken pipe")
+}
+
+func (m mockWriteErrorFlusher) Flush() {}
+
+func TestWriteError(t *testing.T) {
+	streamer := MustNew()
+	w := mockWriteErrorFlusher{NewMockResponseWriter()}
+	r, cancel := NewMockRequest()
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(w, r)
+		close(done)
+	}()
+	waitForClients(t, streamer, 1)
+
+	streamer.SendString("", "", "lost")
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not return after writing failed")
+	}
+	waitForClients(t, streamer, 0)
+}
+
+// mockShortWriteFlusher writes at most 3 bytes per call without returning an
+// error and passes every write to the writes channel.
+type mockShortWriteFlusher struct {
+	mockChanWriteFlusher
+}
+
+func (m mockShortWriteFlusher) Write(p []byte) (n int, err error) {
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	return m.mockChanWriteFlusher.Write(p)
+}
+
+func TestShortWrite(t *testing.T) {
+	streamer := MustNew()
+	w := mockShortWriteFlusher{NewMockChanWriteFlusher()}
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.SendString("1", "msg", "first")
+	streamer.SendString("", "", "second")
+
+	expected := "id:1\nevent:msg\ndata:first\n\ndata:second\n\n"
+	var got string
+	for len(got) < len(expected) {
+		got += recv(t, w.writes)
+	}
+	if got != expected {
+		t.Errorf("expected %q, got: %q", expected, got)
+	}
+}
+
+// zeroWriter writes nothing without returning an error.
+type zeroWriter struct{}
+
+func (zeroWriter) Write(p []byte) (int, error) {
+	return 0, nil
+}
+
+func TestWriteFull(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFull(&buf, []byte("data:x\n\n")); err != nil || buf.String() != "data:x\n\n" {
+		t.Errorf("unexpected result: %q, %v", buf.String(), err)
+	}
+	if err := writeFull(zeroWriter{}, []byte("data:x\n\n")); err != io.ErrShortWrite {
+		t.Error("expected io.ErrShortWrite, got:", err)
+	}
+}
+
 func TestHeader(t *testing.T) {
-	streamer := New()
+	streamer := MustNew()
 	w := NewMockResponseWriteFlushCloser()
 	r, cancel := NewMockRequest()
 
@@ -192,7 +417,7 @@ func TestHeader(t *testing.T) {
 }
 
 func TestSendEvent(t *testing.T) {
-	streamer := New()
+	streamer := MustNew()
 	w := NewMockResponseWriteFlushCloser()
 	r, cancel := NewMockRequest()
 
@@ -211,6 +436,12 @@ func TestSendEvent(t *testing.T) {
 		streamer.SendString("", "msg", "Hi!")
 		expected += "event:msg\ndata:Hi!\n\n"
 
+		streamer.SendString("1", "", "with id")
+		expected += "id:1\ndata:with id\n\n"
+
+		streamer.SendString("2", "msg", "")
+		expected += "id:2\nevent:msg\ndata\n\n"
+
 		streamer.SendString("", "string", "multi\nline\n\nyay")
 		expected += "event:string\ndata:multi\ndata:line\ndata:\ndata:yay\n\n"
 
@@ -235,6 +466,9 @@ func TestSendEvent(t *testing.T) {
 		streamer.SendJSON("", "json", map[string]string{"test": "successful"})
 		expected += "event:json\ndata:{\"test\":\"successful\"}\n\n"
 
+		streamer.SendEvent(Event{ID: "3", Event: "event", Data: []byte("a\nb")})
+		expected += "id:3\nevent:event\ndata:a\ndata:b\n\n"
+
 		time.Sleep(500 * time.Millisecond)
 		cancel()
 	}()
@@ -251,7 +485,7 @@ func TestSendEvent(t *testing.T) {
 }
 
 func TestJSONErr(t *testing.T) {
-	streamer := New()
+	streamer := MustNew()
 	w := NewMockResponseWriteFlushCloser()
 	r, cancel := NewMockRequest()
 
@@ -283,3 +517,1192 @@ func TestJSONErr(t *testing.T) {
 		t.Fatal("wrong body, got:\n", w.written, "\nexpected:\n", expected)
 	}
 }
+
+// largeJSONValue is a value whose JSON encoding has a size of about 64 KiB.
+var largeJSONValue = map[string]string{"data": strings.Repeat("x", 64<<10)}
+
+func BenchmarkSendJSONLarge(b *testing.B) {
+	benchmarkSendJSONLarge(b, MustNew(WithDirectBroadcast()))
+}
+
+func BenchmarkSendJSONLargeMarshaler(b *testing.B) {
+	benchmarkSendJSONLarge(b, MustNew(WithDirectBroadcast(), WithJSONMarshaler(json.Marshal)))
+}
+
+func benchmarkSendJSONLarge(b *testing.B, streamer *Streamer) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		streamer.SendJSON("", "large", largeJSONValue)
+	}
+}
+
+func TestSendJSONAllocs(t *testing.T) {
+	const n = 20
+	streamer := MustNew(WithDirectBroadcast())
+	streamer.SendJSON("", "large", largeJSONValue) // warm up
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < n; i++ {
+		streamer.SendJSON("", "large", largeJSONValue)
+	}
+	runtime.ReadMemStats(&after)
+
+	// The encoded value is only allocated once, as part of the event, instead
+	// of once by the marshaler and once more for the event
+	const size = 64 << 10
+	if bytes := (after.TotalAlloc - before.TotalAlloc) / n; bytes >= 2*size {
+		t.Errorf("expected less than %d allocated bytes per event, got: %d", 2*size, bytes)
+	}
+}
+
+func TestConcurrentProducers(t *testing.T) {
+	const producers, events, clients = 8, 200, 4
+
+	for _, opts := range [][]Option{nil, {WithDirectBroadcast()}} {
+		streamer := MustNew(opts...)
+
+		var wg sync.WaitGroup
+		for c := 0; c < clients; c++ {
+			w := NewMockChanWriteFlusher()
+			r, cancel := NewMockRequest()
+			defer serve(t, streamer, w, r, cancel)()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				next := make([]int, producers)
+				for i := 0; i < producers*events; i++ {
+					var got string
+					select {
+					case got = <-w.writes:
+					case <-time.After(5 * time.Second):
+						t.Error("timeout while waiting for a write")
+						return
+					}
+					var p, n int
+					if _, err := fmt.Sscanf(got, "event:p%d\ndata:%d\n\n", &p, &n); err != nil ||
+						got != fmt.Sprintf("event:p%d\ndata:%d\n\n", p, n) || p >= producers {
+						t.Errorf("corrupted event: %q", got)
+						return
+					}
+					if n != next[p] {
+						t.Errorf("producer %d: expected event %d, got: %d", p, next[p], n)
+						return
+					}
+					next[p]++
+				}
+			}()
+		}
+
+		for p := 0; p < producers; p++ {
+			go func(p int) {
+				event := "p" + strconv.Itoa(p)
+				for n := 0; n < events; n++ {
+					streamer.SendString("", event, strconv.Itoa(n))
+				}
+			}(p)
+		}
+		wg.Wait()
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	streamer := MustNew()
+	events, cancel := streamer.Subscribe(context.Background())
+	defer cancel()
+	if n := streamer.ClientCount(); n != 1 {
+		t.Errorf("expected the subscriber to be connected, got %d clients", n)
+	}
+
+	streamer.SendString("1", "msg", "a")
+	streamer.SendString("", "", "b\nc")
+	streamer.SendString("3", "", "")
+	var expected = []Event{
+		{ID: "1", Event: "msg", Data: []byte("a")},
+		{Data: []byte("b\nc")},
+		{ID: "3", Data: []byte{}},
+	}
+	for _, e := range expected {
+		select {
+		case got := <-events:
+			if got.ID != e.ID || got.Event != e.Event || !bytes.Equal(got.Data, e.Data) {
+				t.Errorf("expected %+v, got: %+v", e, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout while waiting for an event")
+		}
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("expected the channel to be closed")
+	}
+	waitForClients(t, streamer, 0)
+}
+
+func TestSubscribeDone(t *testing.T) {
+	streamer := MustNew()
+
+	// canceling the context unsubscribes
+	ctx, cancel := context.WithCancel(context.Background())
+	events, _ := streamer.Subscribe(ctx)
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("expected the channel to be closed")
+	}
+	waitForClients(t, streamer, 0)
+
+	// closing the Streamer unsubscribes after the remaining events
+	events, unsubscribe := streamer.Subscribe(context.Background())
+	defer unsubscribe()
+	streamer.SendString("", "", "last")
+	go streamer.Close()
+	if e := <-events; string(e.Data) != "last" {
+		t.Errorf("expected the last event, got: %+v", e)
+	}
+	if _, ok := <-events; ok {
+		t.Error("expected the channel to be closed")
+	}
+}
+
+func TestSendPatch(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	patch := map[string]interface{}{"name": "new", "removed": nil}
+	if err := streamer.SendPatch("1", "state", patch); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := streamer.SendPatch("", "", []int{1}); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, expected := range []string{
+		"id:1\nevent:state:patch\ndata:{\"name\":\"new\",\"removed\":null}\n\n",
+		"event:message:patch\ndata:[1]\n\n",
+	} {
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+
+	if err := streamer.SendPatch("", "state", math.Inf(0)); err == nil {
+		t.Error("expected an error for an invalid patch")
+	}
+}
+
+func TestSendJSONStream(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	items := make(chan interface{})
+	go func() {
+		items <- 1
+		items <- "two"
+		items <- []int{3}
+		close(items)
+	}()
+	if err := streamer.SendJSONStream("", "item", items); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	for _, expected := range []string{
+		"event:item\ndata:1\n\n",
+		"event:item\ndata:\"two\"\n\n",
+		"event:item\ndata:[3]\n\n",
+	} {
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+
+	// the stream stops at the first error
+	items = make(chan interface{}, 2)
+	items <- math.Inf(0)
+	items <- 4
+	if err := streamer.SendJSONStream("", "item", items); err == nil {
+		t.Error("expected an error")
+	}
+	if len(items) != 1 {
+		t.Error("expected the remaining item not to be received")
+	}
+}
+
+func TestSendJSONStreamClose(t *testing.T) {
+	streamer := MustNew()
+	done := make(chan error)
+	go func() {
+		done <- streamer.SendJSONStream("", "", make(chan interface{}))
+	}()
+	streamer.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("unexpected error:", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SendJSONStream did not return after Close")
+	}
+}
+
+func TestPing(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.Ping()
+	streamer.SendString("", "", "event")
+	ping := recv(t, w.writes)
+	if ping != ":\n\n" {
+		t.Fatalf("expected an empty comment, got: %q", ping)
+	}
+
+	// the ping is ignored by clients
+	e, err := NewDecoder(strings.NewReader(ping + recv(t, w.writes))).Decode()
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if string(e.Data) != "event" {
+		t.Errorf("expected the event after the ping, got: %q", e.Data)
+	}
+}
+
+func TestPump(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockResponseWriteFlushCloser()
+	r, cancel := NewMockRequest()
+
+	const upstream = ": upstream comment\n\n" +
+		"data:first\n\n" +
+		"id: 42\nevent: update\ndata: multi\ndata: line\n\n" +
+		"event:empty\ndata\n\n"
+	const expected = "data:first\n\n" +
+		"id:42\nevent:update\ndata:multi\ndata:line\n\n" +
+		"event:empty\ndata\n\n"
+
+	var err error
+
+	time.Sleep(500 * time.Millisecond)
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+
+		err = streamer.Pump(context.Background(), strings.NewReader(upstream))
+
+		time.Sleep(500 * time.Millisecond)
+		cancel()
+	}()
+
+	streamer.ServeHTTP(w, r)
+
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if w.written != expected {
+		t.Fatal("wrong body, got:\n", w.written, "\nexpected:\n", expected)
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("upstream failure")
+}
+
+func TestPumpErr(t *testing.T) {
+	streamer := MustNew()
+
+	if err := streamer.Pump(context.Background(), errReader{}); err == nil {
+		t.Fatal("expected an error!")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := streamer.Pump(ctx, strings.NewReader("data:x\n\n"))
+	if err != context.Canceled {
+		t.Fatal("expected context.Canceled, got:", err)
+	}
+}
+
+func TestSendEventReturnsBytes(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	sent := streamer.SendEvent(Event{ID: "1", Event: "audit", Data: []byte("a\nb")})
+	if received := recv(t, w.writes); received != string(sent) {
+		t.Fatal("wrong body, got:\n", received, "\nexpected:\n", string(sent))
+	}
+	if expected := "id:1\nevent:audit\ndata:a\ndata:b\n\n"; string(sent) != expected {
+		t.Fatal("wrong serialized event, got:\n", string(sent), "\nexpected:\n", expected)
+	}
+}
+
+type mockDiscardWriteFlusher struct {
+	*mockResponseWriter
+}
+
+func (m mockDiscardWriteFlusher) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+func (m mockDiscardWriteFlusher) Flush() {}
+
+func benchmarkBroadcast(b *testing.B, opts ...Option) {
+	const clients = 100
+
+	streamer := MustNew(opts...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < clients; i++ {
+		r := NewMockRequestNeverClose().WithContext(ctx)
+		go streamer.ServeHTTP(mockDiscardWriteFlusher{NewMockResponseWriter()}, r)
+	}
+	for streamer.Stats().Clients < clients {
+		time.Sleep(time.Millisecond)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		streamer.SendString("", "bench", "Hello, World!")
+	}
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	benchmarkBroadcast(b)
+}
+
+func BenchmarkBroadcastDirect(b *testing.B) {
+	benchmarkBroadcast(b, WithDirectBroadcast())
+}
+
+func TestSendStringPriority(t *testing.T) {
+	streamer := MustNew(WithDirectBroadcast())
+	streamer.BufSize(4)
+	w := NewMockBlockingWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	// the first event blocks the client in Write
+	streamer.SendString("", "", "1")
+	<-w.writing
+
+	streamer.SendString("", "", "2")
+	streamer.SendStringPriority(0, "", "", "3")
+	streamer.SendStringPriority(1, "", "alert", "4")
+	streamer.SendStringPriority(5, "", "alert", "5")
+	close(w.unblock)
+
+	var expected = []string{
+		"data:1\n\n",
+		"event:alert\ndata:4\n\n",
+		"event:alert\ndata:5\n\n",
+		"data:2\n\n",
+		"data:3\n\n",
+	}
+	for _, e := range expected {
+		if got := recv(t, w.writes); got != e {
+			t.Errorf("wrong event, expected: %q, got: %q", e, got)
+		}
+	}
+}
+
+func TestSendRetained(t *testing.T) {
+	streamer := MustNew()
+
+	streamer.SendRetained("", "status", []byte("old"))
+	streamer.SendRetained("1", "status", []byte("current"))
+	streamer.SendRetained("", "config", []byte("{}"))
+	streamer.SendString("", "status", "not retained")
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.SendString("", "", "live")
+
+	var expected = []string{
+		"event:config\ndata:{}\n\nid:1\nevent:status\ndata:current\n\n",
+		"data:live\n\n",
+	}
+	for _, e := range expected {
+		if got := recv(t, w.writes); got != e {
+			t.Errorf("wrong event, expected: %q, got: %q", e, got)
+		}
+	}
+}
+
+func TestSendEventNow(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockFlushRecorder()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.SendEventNow(Event{Event: "alert", Data: []byte("now")})
+
+	if got := recv(t, w.writes); got != "event:alert\ndata:now\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+	if got := recv(t, w.writes); got != flushMarker {
+		t.Errorf("expected a flush after the event, got: %q", got)
+	}
+}
+
+func TestSendToGroup(t *testing.T) {
+	streamer := MustNew(WithGroupKey(func(r *http.Request) string {
+		return r.Header.Get("X-Tenant")
+	}))
+
+	var writers = make(map[string][]mockChanWriteFlusher)
+	for _, tenant := range []string{"a", "a", "b", ""} {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-Tenant", tenant)
+		stop := serve(t, streamer, w, r, cancel)
+		defer stop()
+		writers[tenant] = append(writers[tenant], w)
+	}
+
+	streamer.SendToGroup("a", Event{Event: "group", Data: []byte("a")})
+	streamer.SendToGroup("c", Event{Event: "group", Data: []byte("c")})
+	streamer.SendToGroup("", Event{Event: "group", Data: []byte("none")})
+	streamer.SendString("", "", "all")
+
+	for _, w := range writers["a"] {
+		if got := recv(t, w.writes); got != "event:group\ndata:a\n\n" {
+			t.Errorf("wrong group event, got: %q", got)
+		}
+	}
+	for _, ws := range writers {
+		for _, w := range ws {
+			if got := recv(t, w.writes); got != "data:all\n\n" {
+				t.Errorf("wrong event, got: %q", got)
+			}
+		}
+	}
+}
+
+func TestSendStringExcept(t *testing.T) {
+	streamer := MustNew(WithClientID(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}))
+
+	var writers = make(map[string]mockChanWriteFlusher)
+	for _, user := range []string{"a", "b"} {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-User", user)
+		stop := serve(t, streamer, w, r, cancel)
+		defer stop()
+		writers[user] = w
+	}
+
+	streamer.SendStringExcept("a", "", "", "from a")
+	streamer.SendString("", "", "all")
+
+	if got := recv(t, writers["b"].writes); got != "data:from a\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+	for _, w := range writers {
+		if got := recv(t, w.writes); got != "data:all\n\n" {
+			t.Errorf("wrong event, got: %q", got)
+		}
+	}
+}
+
+func TestSendStringToHeader(t *testing.T) {
+	streamer := MustNew(WithIndexedHeaders("x-tenant"))
+
+	var writers = make(map[string]mockChanWriteFlusher)
+	for _, tenant := range []string{"acme", "other", ""} {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		stop := serve(t, streamer, w, r, cancel)
+		defer stop()
+		writers[tenant] = w
+	}
+
+	streamer.SendStringToHeader("X-Tenant", "acme", "", "", "acme")
+	streamer.SendStringToHeader("X-Unindexed", "", "", "", "unindexed")
+	streamer.SendString("", "", "all")
+
+	if got := recv(t, writers["acme"].writes); got != "data:acme\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+	for _, w := range writers {
+		if got := recv(t, w.writes); got != "data:all\n\n" {
+			t.Errorf("wrong event, got: %q", got)
+		}
+	}
+}
+
+func TestSendStringCtx(t *testing.T) {
+	streamer := MustNew()
+	streamer.BufSize(1)
+	w := NewMockBlockingWriteFlusher()
+	r, cancel := NewMockRequest()
+
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(w, r)
+		close(done)
+	}()
+	waitForClients(t, streamer, 1)
+
+	// the first event blocks the client in Write, the second fills its buffer,
+	// the third blocks the broadcast and the fourth fills the event queue
+	streamer.SendString("", "", "1")
+	<-w.writing
+	for i := 2; i <= 4; i++ {
+		streamer.SendString("", "", strconv.Itoa(i))
+	}
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelCtx()
+	if err := streamer.SendStringCtx(ctx, "", "", "5"); err != context.DeadlineExceeded {
+		t.Error("expected context.DeadlineExceeded, got:", err)
+	}
+
+	close(w.unblock)
+	for i := 1; i <= 4; i++ {
+		if got, expected := recv(t, w.writes), "data:"+strconv.Itoa(i)+"\n\n"; got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+	if err := streamer.SendStringCtx(context.Background(), "", "", "6"); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	if got := recv(t, w.writes); got != "data:6\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+	cancel()
+	<-done
+}
+
+func TestSend(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	var tests = []struct {
+		v        interface{}
+		expected string
+	}{
+		{"a\nb", "data:a\ndata:b\n\n"},
+		{[]byte("bytes"), "data:bytes\n\n"},
+		{-42, "data:-42\n\n"},
+		{int8(-8), "data:-8\n\n"},
+		{uint(42), "data:42\n\n"},
+		{uint64(math.MaxUint64), "data:18446744073709551615\n\n"},
+		{1.5, "data:1.5\n\n"},
+		{float32(0.1), "data:0.1\n\n"},
+		{struct {
+			A int `json:"a"`
+		}{1}, "data:{\"a\":1}\n\n"},
+		{nil, "data:null\n\n"},
+	}
+	for _, test := range tests {
+		if err := streamer.Send("", "", test.v); err != nil {
+			t.Errorf("%#v: unexpected error: %v", test.v, err)
+		}
+		if got := recv(t, w.writes); got != test.expected {
+			t.Errorf("%#v: expected %q, got: %q", test.v, test.expected, got)
+		}
+	}
+
+	if err := streamer.Send("", "", make(chan int)); err == nil {
+		t.Error("expected an error for a value which can not be encoded")
+	}
+}
+
+func TestFormatNewlines(t *testing.T) {
+	streamer := MustNew()
+
+	var tests = []struct {
+		data     string
+		expected string
+	}{
+		{"", "data\n\n"},
+		{"foo", "data:foo\n\n"},
+		{"foo\n", "data:foo\ndata:\n\n"},
+		{"\n", "data:\ndata:\n\n"},
+		{"\n\n", "data:\ndata:\ndata:\n\n"},
+		{"foo\nbar\n", "data:foo\ndata:bar\ndata:\n\n"},
+	}
+
+	for _, test := range tests {
+		if p := string(streamer.formatString("", "", test.data)); p != test.expected {
+			t.Errorf("formatString(%q): expected %q, got %q", test.data, test.expected, p)
+		}
+		p := streamer.formatBytes("", "", []byte(test.data))
+		if string(p) != test.expected {
+			t.Errorf("formatBytes(%q): expected %q, got %q", test.data, test.expected, p)
+		}
+
+		// clients must receive the original data
+		e, err := NewDecoder(bytes.NewReader(p)).Decode()
+		if err != nil {
+			t.Fatalf("decoding %q: %v", p, err)
+		}
+		if string(e.Data) != test.data {
+			t.Errorf("round trip of %q returned %q", test.data, e.Data)
+		}
+	}
+}
+
+func TestServeHTTPContext(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockResponseWriteFlushCloser()
+	r, cancel := NewMockRequest()
+	defer cancel()
+	ctx, stop := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTPContext(ctx, w, r)
+		close(done)
+	}()
+	waitForClients(t, streamer, 1)
+
+	stop()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not return after the context was cancelled")
+	}
+
+	if r.Context().Err() != nil {
+		t.Fatal("request context must still be live")
+	}
+	waitForClients(t, streamer, 0)
+}
+
+func TestDisconnectFullBuffer(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithDirectBroadcast()}} {
+		streamer := MustNew(opts...)
+		streamer.BufSize(1)
+		w := mockWriteErrorFlusher{NewMockResponseWriter()}
+		r, cancel := NewMockRequest()
+		defer cancel()
+
+		done := make(chan struct{})
+		go func() {
+			streamer.ServeHTTP(w, r)
+			close(done)
+		}()
+		waitForClients(t, streamer, 1)
+
+		// the client fails on the first event, while the broadcast of the
+		// further events blocks on its full buffer
+		for i := 0; i < 5; i++ {
+			streamer.SendString("", "", "x")
+		}
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("handler did not return")
+		}
+		waitForClients(t, streamer, 0)
+	}
+}
+
+func TestSendLines(t *testing.T) {
+	streamer := MustNew(WithDirectBroadcast())
+	streamer.BufSize(10)
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	var tests = []struct {
+		lines    [][]byte
+		expected string
+	}{
+		{nil, "event:lines\ndata\n\n"},
+		{[][]byte{}, "event:lines\ndata\n\n"},
+		{[][]byte{[]byte("single")}, "event:lines\ndata:single\n\n"},
+		{[][]byte{{}}, "event:lines\ndata\n\n"},
+		{[][]byte{{}, {}}, "event:lines\ndata:\ndata:\n\n"},
+		{[][]byte{[]byte("a"), {}, []byte("b")}, "event:lines\ndata:a\ndata:\ndata:b\n\n"},
+	}
+
+	for _, test := range tests {
+		streamer.SendLines("", "lines", test.lines)
+		if got := recv(t, w.writes); got != test.expected {
+			t.Errorf("lines %q: expected %q, got %q", test.lines, test.expected, got)
+		}
+	}
+}
+
+func TestSendDataLines(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	streamer.SendDataLines("1", "lines", []string{"a", "", "b"})
+	if got := recv(t, w.writes); got != "id:1\nevent:lines\ndata:a\ndata:\ndata:b\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+}
+
+func TestFormatBlankLines(t *testing.T) {
+	streamer := MustNew()
+
+	var tests = []struct {
+		data     string
+		expected string
+	}{
+		// leading blank lines
+		{"\nfoo", "data:\ndata:foo\n\n"},
+		{"\n\n\nfoo", "data:\ndata:\ndata:\ndata:foo\n\n"},
+		// consecutive interior blank lines
+		{"foo\n\nbar", "data:foo\ndata:\ndata:bar\n\n"},
+		{"foo\n\n\n\nbar", "data:foo\ndata:\ndata:\ndata:\ndata:bar\n\n"},
+		// blank lines at both boundaries
+		{"\nfoo\n", "data:\ndata:foo\ndata:\n\n"},
+		{"\n\nfoo\n\nbar\n\n", "data:\ndata:\ndata:foo\ndata:\ndata:bar\ndata:\ndata:\n\n"},
+		// all blank
+		{"\n\n\n\n", "data:\ndata:\ndata:\ndata:\ndata:\n\n"},
+	}
+
+	for _, test := range tests {
+		for _, id := range []string{"", "42"} {
+			for _, event := range []string{"", "blank"} {
+				expected := test.expected
+				if event != "" {
+					expected = "event:" + event + "\n" + expected
+				}
+				if id != "" {
+					expected = "id:" + id + "\n" + expected
+				}
+
+				if p := string(streamer.formatString(id, event, test.data)); p != expected {
+					t.Errorf("formatString(%q): expected %q, got %q", test.data, expected, p)
+				}
+				if p := string(streamer.formatBytes(id, event, []byte(test.data))); p != expected {
+					t.Errorf("formatBytes(%q): expected %q, got %q", test.data, expected, p)
+				}
+
+				var lines [][]byte
+				for _, line := range strings.Split(test.data, "\n") {
+					lines = append(lines, []byte(line))
+				}
+				if p := string(streamer.formatLines(id, event, lines)); p != expected {
+					t.Errorf("formatLines(%q): expected %q, got %q", lines, expected, p)
+				}
+			}
+		}
+	}
+}
+
+// chanWriter passes every write to the writes channel.
+type chanWriter chan string
+
+func (c chanWriter) Write(p []byte) (n int, err error) {
+	c <- string(p)
+	return len(p), nil
+}
+
+func TestAddWriter(t *testing.T) {
+	streamer := MustNew()
+	streamer.SendRetained("", "state", []byte("retained"))
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+	recv(t, w.writes) // retained event
+
+	log := make(chanWriter, 10)
+	remove := streamer.AddWriter(log)
+	if n := streamer.Stats().Clients; n != 2 {
+		t.Fatal("expected 2 clients, has:", n)
+	}
+
+	streamer.SendString("", "msg", "both")
+
+	if got := recv(t, log); got != "event:state\ndata:retained\n\n" {
+		t.Errorf("wrong retained event for the writer, got: %q", got)
+	}
+	if got := recv(t, log); got != "event:msg\ndata:both\n\n" {
+		t.Errorf("wrong event for the writer, got: %q", got)
+	}
+	if got := recv(t, w.writes); got != "event:msg\ndata:both\n\n" {
+		t.Errorf("wrong event for the HTTP client, got: %q", got)
+	}
+
+	remove()
+	remove() // no-op
+	if n := streamer.Stats().Clients; n != 1 {
+		t.Fatal("expected 1 client, has:", n)
+	}
+
+	streamer.SendString("", "msg", "http only")
+	recv(t, w.writes)
+	if len(log) != 0 {
+		t.Error("removed writer received an event")
+	}
+}
+
+func TestSendError(t *testing.T) {
+	for _, plain := range []bool{false, true} {
+		var opts []Option
+		expected := "id:1\nevent:error\ndata:{\"error\":\"something \\\"failed\\\"\"}\n\n"
+		if plain {
+			opts = append(opts, WithPlainTextErrors())
+			expected = "id:1\nevent:error\ndata:something \"failed\"\n\n"
+		}
+
+		streamer := MustNew(opts...)
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		stop := serve(t, streamer, w, r, cancel)
+
+		if err := streamer.SendError("", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := streamer.SendError("1", errors.New(`something "failed"`)); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("wrong error event, expected: %q, got: %q", expected, got)
+		}
+		stop()
+		if len(w.writes) != 0 {
+			t.Error("nil error sent an event")
+		}
+	}
+}
+
+func TestCloseWithEvent(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		var streamer *Streamer
+		if direct {
+			streamer = MustNew(WithDirectBroadcast())
+		} else {
+			streamer = MustNew()
+		}
+
+		var writers []mockChanWriteFlusher
+		var done []chan struct{}
+		for i := 0; i < 2; i++ {
+			w := NewMockChanWriteFlusher()
+			r, cancel := NewMockRequest()
+			defer cancel()
+			d := make(chan struct{})
+			go func() {
+				streamer.ServeHTTP(w, r)
+				close(d)
+			}()
+			waitForClients(t, streamer, i+1)
+			writers = append(writers, w)
+			done = append(done, d)
+		}
+
+		streamer.SendString("", "", "last")
+		streamer.CloseWithEvent(Event{Event: "close", Data: []byte("bye")})
+
+		for i, w := range writers {
+			<-done[i] // the handler returns after CloseWithEvent
+			for _, expected := range []string{"data:last\n\n", "event:close\ndata:bye\n\n"} {
+				if got := recv(t, w.writes); got != expected {
+					t.Errorf("direct=%v: expected %q, got: %q", direct, expected, got)
+				}
+			}
+		}
+
+		// events are discarded and new clients rejected after Close
+		streamer.SendString("", "", "discarded")
+		w := NewMockResponseWriteFlusher()
+		r, cancel := NewMockRequest()
+		streamer.ServeHTTP(w, r)
+		cancel()
+		if w.status != http.StatusServiceUnavailable {
+			t.Errorf("direct=%v: expected status 503 after Close, got: %d", direct, w.status)
+		}
+
+		// closing again does not block
+		streamer.Close()
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	streamer := MustNew()
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	const batches = 20
+	var wg sync.WaitGroup
+	for _, producer := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(producer string) {
+			defer wg.Done()
+			for i := 0; i < batches; i++ {
+				streamer.SendBatch(
+					Event{ID: strconv.Itoa(i), Event: producer, Data: []byte("1")},
+					Event{Event: producer, Data: []byte("2")},
+					Event{Event: producer, Data: []byte("3")},
+				)
+			}
+		}(producer)
+	}
+
+	var stream string
+	for i := 0; i < 2*batches; i++ {
+		stream += recv(t, w.writes)
+	}
+	wg.Wait()
+
+	events := decodeAll(t, stream)
+	if len(events) != 2*batches*3 {
+		t.Fatalf("expected %d events, got: %d", 2*batches*3, len(events))
+	}
+	for i := 0; i < len(events); i += 3 {
+		for j, e := range events[i : i+3] {
+			if e.Event != events[i].Event || string(e.Data) != strconv.Itoa(j+1) {
+				t.Fatalf("interleaved batch at event %d: %+v", i+j, events[i:i+3])
+			}
+		}
+	}
+}
+
+func TestDisconnectTwice(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		var streamer *Streamer
+		if direct {
+			streamer = MustNew(WithDirectBroadcast())
+		} else {
+			streamer = MustNew()
+		}
+
+		cl := streamer.newClient()
+		streamer.connect(cl, "")
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				streamer.disconnect(cl)
+			}()
+		}
+		wg.Wait()
+		streamer.disconnect(cl)
+
+		if n := streamer.Stats().Clients; n != 0 {
+			t.Errorf("direct=%v: expected 0 clients, has: %d", direct, n)
+		}
+	}
+
+	// the connection is closed while writing fails
+	streamer := MustNew()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		w := mockWriteErrorFlusher{NewMockResponseWriter()}
+		r, cancel := NewMockRequest()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			streamer.ServeHTTP(w, r)
+		}()
+		waitForClients(t, streamer, 1)
+		go cancel()
+		streamer.SendString("", "", "x")
+		wg.Wait()
+	}
+	waitForClients(t, streamer, 0)
+}
+
+func TestPauseClient(t *testing.T) {
+	streamer := MustNew(WithOverflowPolicy(OverflowDrop), WithClientID(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}))
+
+	// only the buffer of the paused client a is small enough to overflow
+	var writers = make(map[string]mockChanWriteFlusher)
+	for _, user := range []string{"a", "b"} {
+		if user == "a" {
+			streamer.BufSize(2)
+		} else {
+			streamer.BufSize(10)
+		}
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-User", user)
+		stop := serve(t, streamer, w, r, cancel)
+		defer stop()
+		writers[user] = w
+	}
+
+	streamer.PauseClient("a")
+	for i := 1; i <= 3; i++ {
+		streamer.SendString("", "", strconv.Itoa(i))
+	}
+
+	// the other client is not paused
+	for i := 1; i <= 3; i++ {
+		if got, expected := recv(t, writers["b"].writes), "data:"+strconv.Itoa(i)+"\n\n"; got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+	select {
+	case got := <-writers["a"].writes:
+		t.Fatalf("unexpected write to a paused client: %q", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// the buffered events are delivered after resuming, the third was dropped
+	streamer.ResumeClient("a")
+	for i := 1; i <= 2; i++ {
+		if got, expected := recv(t, writers["a"].writes), "data:"+strconv.Itoa(i)+"\n\n"; got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+	streamer.SendString("", "", "4")
+	if got := recv(t, writers["a"].writes); got != "data:4\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+}
+
+func TestEventTTL(t *testing.T) {
+	streamer := MustNew(WithClientID(func(r *http.Request) string { return "slow" }))
+	streamer.BufSize(10)
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	// the events wait in the buffer of the paused client
+	streamer.PauseClient("slow")
+	streamer.SendEvent(Event{Data: []byte("expired"), TTL: 10 * time.Millisecond})
+	streamer.SendEventNow(Event{Data: []byte("expired now"), TTL: 10 * time.Millisecond})
+	streamer.SendBatch(
+		Event{Data: []byte("expired batch")},
+		Event{Data: []byte("expired batch"), TTL: 10 * time.Millisecond},
+	)
+	streamer.SendEvent(Event{Data: []byte("fresh"), TTL: time.Minute})
+	streamer.SendEvent(Event{Data: []byte("forever")})
+	waitFor(t, func() bool {
+		return streamer.ClientMetrics()[0].Buffered == 5
+	})
+	time.Sleep(20 * time.Millisecond)
+	streamer.ResumeClient("slow")
+
+	for _, expected := range []string{"data:fresh\n\n", "data:forever\n\n"} {
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+}
+
+func TestServeSSE(t *testing.T) {
+	events := make(chan Event)
+	streamer := ServeSSE(events).(*Streamer)
+
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(w, r)
+		close(done)
+	}()
+	waitForClients(t, streamer, 1)
+
+	events <- Event{ID: "1", Data: []byte("one")}
+	events <- Event{Event: "msg", Data: []byte("two")}
+	for _, expected := range []string{"id:1\ndata:one\n\n", "event:msg\ndata:two\n\n"} {
+		if got := recv(t, w.writes); got != expected {
+			t.Errorf("expected %q, got: %q", expected, got)
+		}
+	}
+
+	// closing the channel disconnects the clients
+	close(events)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeHTTP did not return after the channel was closed")
+	}
+}
+
+func ExampleServeSSE() {
+	prices := make(chan Event)
+	http.Handle("/prices", ServeSSE(prices))
+
+	go func() {
+		defer close(prices)
+		for i := 0; i < 10; i++ {
+			prices <- Event{Event: "price", Data: []byte(strconv.Itoa(100 + i))}
+			time.Sleep(time.Second)
+		}
+	}()
+}
+
+func TestDisconnectClient(t *testing.T) {
+	streamer := MustNew(WithClientID(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}))
+
+	connect := func(user string) (done chan struct{}) {
+		r, cancel := NewMockRequest()
+		r.Header.Set("X-User", user)
+		done = make(chan struct{})
+		go func() {
+			defer cancel()
+			streamer.ServeHTTP(NewMockChanWriteFlusher(), r)
+			close(done)
+		}()
+		return
+	}
+	a1, a2, b := connect("a"), connect("a"), connect("b")
+	waitForClients(t, streamer, 3)
+
+	if !streamer.DisconnectClient("a") {
+		t.Error("expected clients to be disconnected")
+	}
+	for _, done := range []chan struct{}{a1, a2} {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("handler did not return")
+		}
+	}
+	waitForClients(t, streamer, 1)
+
+	if streamer.DisconnectClient("unknown") {
+		t.Error("expected no client to be disconnected")
+	}
+	select {
+	case <-b:
+		t.Error("other client was disconnected")
+	default:
+	}
+	streamer.Close()
+	<-b
+}
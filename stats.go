@@ -0,0 +1,153 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the state of a Streamer.
+type Stats struct {
+	// Clients is the number of currently connected clients.
+	Clients int
+
+	// Dropped is the total number of events dropped because of full client
+	// buffers, including those of already disconnected clients.
+	Dropped uint64
+
+	// MaxClientDropped is the highest number of dropped events of a single
+	// currently connected client. Clients with many dropped events are
+	// candidates for disconnection.
+	MaxClientDropped uint64
+
+	// QueuedEvents is the number of events waiting to be broadcast, see
+	// QueuedEvents.
+	QueuedEvents int
+
+	// AvgTimeToFirstEvent and MaxTimeToFirstEvent are the average and the
+	// highest time from the registration of an HTTP client to the first event
+	// written to it, including the initial events like the backlog, over all
+	// clients which received an event so far. High times indicate a slow
+	// connection warmup, e.g. a slow backlog.
+	AvgTimeToFirstEvent time.Duration
+	MaxTimeToFirstEvent time.Duration
+}
+
+// Stats returns a snapshot of the current state of the Streamer.
+func (s *Streamer) Stats() (stats Stats) {
+	s.query(func() {
+		stats.Clients = len(s.clients)
+		stats.Dropped = atomic.LoadUint64(&s.dropped)
+		for cl := range s.clients {
+			if cl.dropped > stats.MaxClientDropped {
+				stats.MaxClientDropped = cl.dropped
+			}
+		}
+	})
+	stats.QueuedEvents = s.QueuedEvents()
+	if n := atomic.LoadUint64(&s.firstEvents); n > 0 {
+		stats.AvgTimeToFirstEvent = time.Duration(uint64(atomic.LoadInt64(&s.firstEventSum)) / n)
+		stats.MaxTimeToFirstEvent = time.Duration(atomic.LoadInt64(&s.firstEventMax))
+	}
+	return
+}
+
+// recordFirstEvent records the time from the registration of a client to the
+// first event written to it, see Stats.
+func (s *Streamer) recordFirstEvent(d time.Duration) {
+	atomic.AddInt64(&s.firstEventSum, int64(d))
+	atomic.AddUint64(&s.firstEvents, 1)
+	for {
+		max := atomic.LoadInt64(&s.firstEventMax)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&s.firstEventMax, max, int64(d)) {
+			return
+		}
+	}
+}
+
+// QueuedEvents returns the number of sent events waiting to be broadcast to
+// the clients, see WithEventQueueSize. A continuously high number means that
+// the broadcast can not keep up with the producers, e.g. because of slow
+// clients with the overflow policy OverflowBlock.
+// The number is only a snapshot, which may be outdated immediately.
+// In direct broadcast mode, events are never queued.
+func (s *Streamer) QueuedEvents() int {
+	return len(s.event)
+}
+
+// ClientMetric is a snapshot of the state of a single connected client.
+type ClientMetric struct {
+	// ID is the ID of the client, see WithClientID.
+	ID string
+
+	// Buffered is the number of events waiting in the buffers of the client,
+	// including high priority events. Clients with a continuously high number
+	// of buffered events are lagging.
+	Buffered int
+
+	// BufferSize is the capacity of the buffer of the client, see BufSize.
+	// High priority events have a separate buffer of the same size.
+	BufferSize int
+
+	// Sent is the number of events passed to the buffer of the client.
+	Sent uint64
+
+	// Dropped is the number of events dropped because the buffer of the
+	// client was full.
+	Dropped uint64
+}
+
+// ClientMetrics returns a snapshot of the state of each connected client.
+// The order of the clients is unspecified.
+func (s *Streamer) ClientMetrics() []ClientMetric {
+	var metrics []ClientMetric
+	s.query(func() {
+		metrics = make([]ClientMetric, 0, len(s.clients))
+		for cl := range s.clients {
+			metrics = append(metrics, ClientMetric{
+				ID:         cl.id,
+				Buffered:   len(cl.ch) + len(cl.prio),
+				BufferSize: cap(cl.ch),
+				Sent:       cl.sent,
+				Dropped:    cl.dropped,
+			})
+		}
+	})
+	return metrics
+}
+
+// healthTimeout is the time in which the run goroutine must respond to a
+// liveness check, see Healthy.
+var healthTimeout = time.Second
+
+// Healthy reports whether the Streamer is able to broadcast events, i.e.
+// whether it is not closed and its run goroutine responds within a second.
+// It returns false if the broadcast is blocked, e.g. by slow clients with the
+// overflow policy OverflowBlock. This can be used for readiness checks of
+// load balancers.
+// In direct broadcast mode, there is no run goroutine and only closing is
+// reported.
+func (s *Streamer) Healthy() bool {
+	select {
+	case <-s.closed:
+		return false
+	default:
+	}
+	if s.direct {
+		return true
+	}
+
+	timer := time.NewTimer(healthTimeout)
+	defer timer.Stop()
+	select {
+	case s.pings <- struct{}{}:
+		return true
+	case <-s.stopped:
+		return false
+	case <-timer.C:
+		return false
+	}
+}
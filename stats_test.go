@@ -0,0 +1,214 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStatsDropped(t *testing.T) {
+	streamer := MustNew(WithOverflowPolicy(OverflowDrop))
+	streamer.BufSize(2)
+	w := NewMockBlockingWriteFlusher()
+	r, cancel := NewMockRequest()
+
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(w, r)
+		close(done)
+	}()
+	waitForClients(t, streamer, 1)
+
+	// the first event blocks the client in Write
+	streamer.SendString("", "", "1")
+	<-w.writing
+
+	// 2 events fill the buffer, the others are dropped
+	for i := 2; i <= 5; i++ {
+		streamer.SendString("", "", "x")
+	}
+	waitFor(t, func() bool {
+		return streamer.Stats().Dropped == 2
+	})
+
+	stats := streamer.Stats()
+	if stats.Clients != 1 {
+		t.Error("expected 1 client, has:", stats.Clients)
+	}
+	if stats.MaxClientDropped != 2 {
+		t.Error("expected 2 dropped events for the client, got:", stats.MaxClientDropped)
+	}
+
+	cancel()
+	close(w.unblock)
+	<-done
+
+	// dropped events of disconnected clients remain counted
+	waitForClients(t, streamer, 0)
+	if dropped := streamer.Stats().Dropped; dropped != 2 {
+		t.Error("expected 2 dropped events, got:", dropped)
+	}
+}
+
+func TestTimeToFirstEvent(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	streamer := MustNew(
+		WithHeartbeat(time.Millisecond),
+		WithBacklog(func(r *http.Request) []Event {
+			if r.URL.RawQuery == "slow" {
+				time.Sleep(delay)
+				return []Event{{Data: []byte("backlog")}}
+			}
+			return nil
+		}),
+	)
+
+	// heartbeats are not events
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	recv(t, w.writes)
+	if stats := streamer.Stats(); stats.MaxTimeToFirstEvent != 0 {
+		t.Errorf("expected no time to the first event, got: %v", stats.MaxTimeToFirstEvent)
+	}
+	streamer.SendString("", "", "live")
+	for recv(t, w.writes) != "data:live\n\n" {
+	}
+	waitFor(t, func() bool {
+		return streamer.Stats().MaxTimeToFirstEvent > 0
+	})
+	stop()
+
+	w = NewMockChanWriteFlusher()
+	r, cancel = NewMockRequest()
+	r.URL.RawQuery = "slow"
+	defer serve(t, streamer, w, r, cancel)()
+	if got := recv(t, w.writes); got != "data:backlog\n\n" {
+		t.Fatalf("expected the backlog, got: %q", got)
+	}
+	waitFor(t, func() bool {
+		return streamer.Stats().MaxTimeToFirstEvent >= delay
+	})
+
+	stats := streamer.Stats()
+	if stats.MaxTimeToFirstEvent > delay+time.Second {
+		t.Errorf("expected a max time to the first event of about %v, got: %v", delay, stats.MaxTimeToFirstEvent)
+	}
+	if stats.AvgTimeToFirstEvent < delay/2 || stats.AvgTimeToFirstEvent >= stats.MaxTimeToFirstEvent {
+		t.Errorf("expected an average time to the first event of about %v, got: %v", delay/2, stats.AvgTimeToFirstEvent)
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	streamer := MustNew(WithClientID(func(r *http.Request) string {
+		return "client"
+	}))
+	streamer.BufSize(4)
+	w := NewMockBlockingWriteFlusher()
+	r, cancel := NewMockRequest()
+
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(w, r)
+		close(done)
+	}()
+	waitForClients(t, streamer, 1)
+
+	// the first event blocks the client in Write, the others are buffered
+	streamer.SendString("", "", "1")
+	<-w.writing
+	streamer.SendString("", "", "2")
+	streamer.SendString("", "", "3")
+
+	var metrics []ClientMetric
+	waitFor(t, func() bool {
+		metrics = streamer.ClientMetrics()
+		return len(metrics) == 1 && metrics[0].Sent == 3
+	})
+	expected := ClientMetric{ID: "client", Buffered: 2, BufferSize: 4, Sent: 3}
+	if metrics[0] != expected {
+		t.Errorf("expected %+v, got: %+v", expected, metrics[0])
+	}
+
+	cancel()
+	close(w.unblock)
+	<-done
+	if metrics := streamer.ClientMetrics(); len(metrics) != 0 {
+		t.Error("expected no metrics without clients, got:", metrics)
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	defer func(timeout time.Duration) {
+		healthTimeout = timeout
+	}(healthTimeout)
+	healthTimeout = 10 * time.Millisecond
+
+	streamer := MustNew()
+	streamer.BufSize(0)
+	if !streamer.Healthy() {
+		t.Fatal("expected a new Streamer to be healthy")
+	}
+
+	// a client blocked in Write wedges the broadcast
+	w := NewMockBlockingWriteFlusher()
+	r, cancel := NewMockRequest()
+	done := make(chan struct{})
+	go func() {
+		streamer.ServeHTTP(w, r)
+		close(done)
+	}()
+	waitForClients(t, streamer, 1)
+	streamer.SendString("", "", "1")
+	<-w.writing
+	streamer.SendString("", "", "2")
+	if streamer.Healthy() {
+		t.Error("expected a blocked Streamer to be unhealthy")
+	}
+
+	close(w.unblock)
+	waitFor(t, streamer.Healthy)
+	cancel()
+	<-done
+
+	streamer.Close()
+	if streamer.Healthy() {
+		t.Error("expected a closed Streamer to be unhealthy")
+	}
+}
+
+func TestQueuedEvents(t *testing.T) {
+	const n = 3
+
+	streamer := MustNew(WithEventQueueSize(n))
+	streamer.BufSize(1)
+	w := NewMockBlockingWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	if stats := streamer.Stats(); stats.QueuedEvents != 0 {
+		t.Errorf("expected no queued events, got: %d", stats.QueuedEvents)
+	}
+
+	// block the broadcast on the client with a full buffer
+	streamer.SendString("", "", "blocked")
+	<-w.writing
+	streamer.SendString("", "", "buffered")
+	streamer.SendString("", "", "blocking")
+
+	for i := 0; i < n; i++ {
+		streamer.SendString("", "", "queued")
+	}
+	waitFor(t, func() bool {
+		return streamer.QueuedEvents() == n
+	})
+	close(w.unblock)
+	waitFor(t, func() bool {
+		return streamer.Stats().QueuedEvents == 0
+	})
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"strconv"
+	"time"
+)
+
+// TimeFormat is the format of the server time sent by SendServerTime.
+type TimeFormat int
+
+const (
+	// TimeRFC3339 formats the time as RFC 3339 with nanoseconds in UTC,
+	// e.g. "2006-01-02T15:04:05.999999999Z". This is the default.
+	TimeRFC3339 TimeFormat = iota
+
+	// TimeUnixMilli formats the time as the number of milliseconds since the
+	// Unix epoch, e.g. "1136214245999", which can be passed to new Date() in
+	// JavaScript.
+	TimeUnixMilli
+)
+
+// TimeSyncEvent is the event type of the server time events sent
+// periodically, see WithTimeSync.
+const TimeSyncEvent = "time"
+
+// WithTimeFormat sets the format of the server time sent by SendServerTime.
+func WithTimeFormat(format TimeFormat) Option {
+	return func(s *Streamer) {
+		s.timeFormat = format
+	}
+}
+
+// WithTimeSync enables sending the current server time to all clients
+// periodically with the given interval, as events of type TimeSyncEvent.
+// Clients can use it to align their timers with the server. The events are
+// sent until the Streamer is closed.
+func WithTimeSync(interval time.Duration) Option {
+	return func(s *Streamer) {
+		s.timeSync = interval
+	}
+}
+
+// SendServerTime sends an event with the current server time as the data
+// value to all connected clients, see WithTimeFormat.
+// If the event string is empty, no event type is send.
+func (s *Streamer) SendServerTime(event string) {
+	if s.skip() {
+		return
+	}
+	s.SendString("", event, s.formatTime(time.Now()))
+}
+
+// formatTime formats t in the configured time format.
+func (s *Streamer) formatTime(t time.Time) string {
+	if s.timeFormat == TimeUnixMilli {
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// runTimeSync starts a goroutine which periodically sends the server time
+// until the Streamer is closed.
+func (s *Streamer) runTimeSync() {
+	go func() {
+		ticker := time.NewTicker(s.timeSync)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.SendServerTime(TimeSyncEvent)
+			case <-s.closed:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recvTime receives the next event and parses its data as a time of the given
+// format.
+func recvTime(t *testing.T, c <-chan string, event string, format TimeFormat) time.Time {
+	got := recv(t, c)
+	prefix := "event:" + event + "\ndata:"
+	if !strings.HasPrefix(got, prefix) || !strings.HasSuffix(got, "\n\n") {
+		t.Fatalf("wrong event, got: %q", got)
+	}
+	data := got[len(prefix) : len(got)-2]
+
+	if format == TimeUnixMilli {
+		ms, err := strconv.ParseInt(data, 10, 64)
+		if err != nil {
+			t.Fatal("invalid time:", err)
+		}
+		return time.Unix(0, ms*int64(time.Millisecond))
+	}
+	ts, err := time.Parse(time.RFC3339Nano, data)
+	if err != nil {
+		t.Fatal("invalid time:", err)
+	}
+	return ts
+}
+
+func TestSendServerTime(t *testing.T) {
+	for _, format := range []TimeFormat{TimeRFC3339, TimeUnixMilli} {
+		streamer := MustNew(WithTimeFormat(format))
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		stop := serve(t, streamer, w, r, cancel)
+
+		before := time.Now().Add(-time.Millisecond)
+		streamer.SendServerTime("clock")
+		ts := recvTime(t, w.writes, "clock", format)
+		if ts.Before(before) || ts.After(time.Now()) {
+			t.Errorf("format %d: time %v is not the current time", format, ts)
+		}
+		stop()
+	}
+}
+
+func TestTimeSync(t *testing.T) {
+	streamer := MustNew(WithTimeSync(10 * time.Millisecond))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	defer cancel()
+	go streamer.ServeHTTP(w, r)
+
+	for i := 0; i < 2; i++ {
+		recvTime(t, w.writes, TimeSyncEvent, TimeRFC3339)
+	}
+	streamer.Close()
+}
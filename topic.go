@@ -0,0 +1,220 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// WithTopicParam enables topic subscriptions. Connecting clients subscribe to
+// topics with the given query parameter, which may be repeated or contain a
+// comma-separated list, e.g. "?topic=news&topic=orders.*".
+// Events can be sent to the subscribers of a topic with SendStringTo.
+//
+// A subscription is either a topic name, which matches only this exact topic,
+// or a pattern ending with "*", which matches all topics starting with the
+// part before the "*". For example, "orders.*" matches "orders.123" and
+// "orders.eu.7", but not "orders", and "*" matches all topics. A "*" anywhere
+// else is matched literally.
+func WithTopicParam(param string) Option {
+	return func(s *Streamer) {
+		s.topicParam = param
+	}
+}
+
+// WithTopicRetention enables retaining the latest event of each topic, see
+// SendStringTo. Clients subscribing to a topic receive its retained event
+// when they connect, which gives them the current state of the topic, like
+// retained messages in MQTT. Clients subscribing to a pattern receive the
+// retained events of all matching topics, ordered by topic.
+// Note that the retained events are kept until the Streamer is garbage
+// collected, thus the number of topics should be bounded.
+func WithTopicRetention() Option {
+	return func(s *Streamer) {
+		s.topicRetained = make(map[string]message)
+	}
+}
+
+// subscriptions are the topic subscriptions of a client.
+type subscriptions struct {
+	topics   map[string]bool
+	prefixes []string // patterns without the trailing "*"
+}
+
+// parseSubscriptions parses the topic subscriptions from the query parameter
+// of the request.
+func parseSubscriptions(r *http.Request, param string) *subscriptions {
+	var subs subscriptions
+	for _, value := range r.URL.Query()[param] {
+		for _, topic := range strings.Split(value, ",") {
+			switch {
+			case topic == "":
+			case strings.HasSuffix(topic, "*"):
+				subs.prefixes = append(subs.prefixes, topic[:len(topic)-1])
+			default:
+				if subs.topics == nil {
+					subs.topics = make(map[string]bool)
+				}
+				subs.topics[topic] = true
+			}
+		}
+	}
+	return &subs
+}
+
+// match reports whether the topic matches any of the subscriptions.
+func (subs *subscriptions) match(topic string) bool {
+	if subs == nil {
+		return false
+	}
+	if subs.topics[topic] {
+		return true
+	}
+	for _, prefix := range subs.prefixes {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SendStringTo sends an event with the given data string to all connected
+// clients subscribed to the given topic, see WithTopicParam.
+// If the id or event string is empty, no id / event type is send.
+func (s *Streamer) SendStringTo(topic, id, event, data string) {
+	if topic == "" || (s.topicRetained == nil && s.skip()) {
+		return
+	}
+	m := s.newMessage(id, event, s.formatString(id, event, data), func() []byte {
+		return []byte(data)
+	})
+	m.topic = topic
+	s.send(m)
+}
+
+// Topics returns the subscriptions of all connected clients and the number of
+// clients subscribed to each. Patterns are returned as they were subscribed,
+// e.g. "orders.*", and are not expanded to the matching topics.
+// The returned map is a copy and may be modified.
+func (s *Streamer) Topics() map[string]int {
+	topics := make(map[string]int)
+	s.query(func() {
+		for cl := range s.clients {
+			if cl.subs == nil {
+				continue
+			}
+			for topic := range cl.subs.topics {
+				topics[topic]++
+			}
+			for _, prefix := range cl.subs.prefixes {
+				topics[prefix+"*"]++
+			}
+		}
+	})
+	return topics
+}
+
+// DrainTopic decommissions a topic, e.g. a chat room which is closed, while
+// the rest of the Streamer keeps running. Connecting clients can no longer
+// subscribe to the topic and the subscriptions of connected clients to it are
+// removed. Clients left without any subscription are sent their buffered
+// events and then disconnected. Subscriptions to patterns matching the topic
+// are not affected, see WithTopicParam.
+// DrainTopic waits until these clients are disconnected or the context is
+// done, in which case the error of the context is returned.
+func (s *Streamer) DrainTopic(ctx context.Context, topic string) error {
+	return s.drainTopic(ctx, topic, nil)
+}
+
+// DrainTopicWithEvent is like DrainTopic, but first sends a final event to all
+// subscribers of the topic, including those subscribed to a matching pattern,
+// e.g. to tell them that the chat room was closed. The event is delivered
+// after all previously sent events.
+func (s *Streamer) DrainTopicWithEvent(ctx context.Context, topic string, e Event) error {
+	return s.drainTopic(ctx, topic, &e)
+}
+
+func (s *Streamer) drainTopic(ctx context.Context, topic string, final *Event) error {
+	var gone []chan struct{}
+	s.query(func() {
+		if s.drainedTopics == nil {
+			s.drainedTopics = make(map[string]bool)
+		}
+		s.drainedTopics[topic] = true
+
+		if final != nil {
+			s.dispatchQueued()
+			if p := s.formatBytes(final.ID, final.Event, final.Data); p != nil {
+				m := s.newMessage(final.ID, final.Event, p, func() []byte {
+					return final.Data
+				})
+				m.topic = topic
+				s.dispatch(m)
+			}
+		}
+		delete(s.topicRetained, topic)
+
+		for cl := range s.clients {
+			if cl.subs == nil || !cl.subs.topics[topic] {
+				continue
+			}
+			delete(cl.subs.topics, topic)
+			if len(cl.subs.topics) > 0 || len(cl.subs.prefixes) > 0 {
+				continue
+			}
+			// The buffered events are accounted when they are written,
+			// thus unlike evict, they are not released here.
+			cl.drained = true
+			s.remove(cl)
+			close(cl.evicted)
+			gone = append(gone, cl.gone)
+		}
+	})
+
+	for _, done := range gone {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// unsubscribeDrained removes the subscriptions of a connecting client to
+// drained topics, see DrainTopic.
+func (s *Streamer) unsubscribeDrained(cl *client) {
+	if cl.subs == nil {
+		return
+	}
+	for topic := range s.drainedTopics {
+		delete(cl.subs.topics, topic)
+	}
+}
+
+// retainedTopics returns the retained events of all topics the client is
+// subscribed to, ordered by topic, see WithTopicRetention.
+func (s *Streamer) retainedTopics(cl *client) (events [][]byte) {
+	if len(s.topicRetained) == 0 || cl.subs == nil {
+		return nil
+	}
+	var topics []string
+	for topic := range s.topicRetained {
+		if cl.subs.match(topic) {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		m := s.topicRetained[topic]
+		if p := m.bytes(cl.framing); p != nil {
+			events = append(events, p)
+		}
+	}
+	return events
+}
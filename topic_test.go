@@ -0,0 +1,192 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionsMatch(t *testing.T) {
+	var tests = []struct {
+		query string
+		topic string
+		match bool
+	}{
+		{"topic=orders.123", "orders.123", true},
+		{"topic=orders.123", "orders.1234", false},
+		{"topic=orders.*", "orders.123", true},
+		{"topic=orders.*", "orders.eu.7", true},
+		{"topic=orders.*", "orders", false},
+		{"topic=orders.*", "news", false},
+		{"topic=*", "news", true},
+		{"topic=a*b", "a*b", true},
+		{"topic=a*b", "axb", false},
+		{"topic=news,orders.*", "orders.1", true},
+		{"topic=news&topic=orders.*", "news", true},
+		{"other=news", "news", false},
+		{"", "news", false},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/?"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match := parseSubscriptions(r, "topic").match(test.topic); match != test.match {
+			t.Errorf("%q matching %q: expected %v, got: %v", test.query, test.topic, test.match, match)
+		}
+	}
+}
+
+func TestSendStringTo(t *testing.T) {
+	streamer := MustNew(WithTopicParam("topic"))
+
+	var writers = make(map[string]mockChanWriteFlusher)
+	for _, query := range []string{"topic=orders.123", "topic=orders.*", "topic=news", ""} {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.URL.RawQuery = query
+		stop := serve(t, streamer, w, r, cancel)
+		defer stop()
+		writers[query] = w
+	}
+
+	streamer.SendStringTo("orders.123", "", "", "order")
+	streamer.SendStringTo("news", "", "", "news")
+	streamer.SendString("", "", "all")
+
+	var expected = map[string][]string{
+		"topic=orders.123": {"data:order\n\n"},
+		"topic=orders.*":   {"data:order\n\n"},
+		"topic=news":       {"data:news\n\n"},
+	}
+	for query, w := range writers {
+		for _, event := range append(expected[query], "data:all\n\n") {
+			if got := recv(t, w.writes); got != event {
+				t.Errorf("%q: expected %q, got: %q", query, event, got)
+			}
+		}
+	}
+}
+
+func TestTopics(t *testing.T) {
+	streamer := MustNew(WithTopicParam("topic"))
+	if topics := streamer.Topics(); len(topics) != 0 {
+		t.Errorf("expected no topics, got: %v", topics)
+	}
+
+	for _, query := range []string{"topic=news,orders.*", "topic=news", "topic=news&topic=sports", ""} {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.URL.RawQuery = query
+		stop := serve(t, streamer, w, r, cancel)
+		defer stop()
+	}
+
+	expected := map[string]int{"news": 3, "orders.*": 1, "sports": 1}
+	topics := streamer.Topics()
+	if !reflect.DeepEqual(topics, expected) {
+		t.Errorf("expected %v, got: %v", expected, topics)
+	}
+
+	// the returned map is a copy
+	topics["news"] = 0
+	if n := streamer.Topics()["news"]; n != 3 {
+		t.Errorf("expected 3 subscribers, got: %d", n)
+	}
+}
+
+func TestTopicRetention(t *testing.T) {
+	streamer := MustNew(WithDirectBroadcast(), WithTopicParam("topic"), WithTopicRetention())
+	streamer.SendStringTo("orders.2", "", "", "order 2 v1")
+	streamer.SendStringTo("orders.2", "", "", "order 2 v2")
+	streamer.SendStringTo("orders.1", "", "", "order 1")
+	streamer.SendStringTo("news", "", "", "news")
+
+	var tests = []struct {
+		query    string
+		expected []string
+	}{
+		{"topic=news", []string{"data:news\n\n"}},
+		{"topic=orders.*", []string{"data:order 1\n\ndata:order 2 v2\n\n"}},
+		{"topic=sports", nil},
+	}
+	for _, test := range tests {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.URL.RawQuery = test.query
+		stop := serve(t, streamer, w, r, cancel)
+
+		for _, expected := range test.expected {
+			if got := recv(t, w.writes); got != expected {
+				t.Errorf("%q: expected %q, got: %q", test.query, expected, got)
+			}
+		}
+		streamer.SendString("", "", "live")
+		if got := recv(t, w.writes); got != "data:live\n\n" {
+			t.Errorf("%q: expected the live event, got: %q", test.query, got)
+		}
+		stop()
+	}
+}
+
+func TestDrainTopic(t *testing.T) {
+	streamer := MustNew(WithTopicParam("topic"), WithTopicRetention())
+
+	var writers = make(map[string]mockChanWriteFlusher)
+	for _, query := range []string{"topic=room.1", "topic=room.1,room.2", "topic=room.*", "topic=room.2"} {
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.URL.RawQuery = query
+		stop := serve(t, streamer, w, r, cancel)
+		defer stop()
+		writers[query] = w
+	}
+
+	streamer.SendStringTo("room.1", "", "", "before")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := streamer.DrainTopicWithEvent(ctx, "room.1", Event{Event: "closed"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// only the client subscribed to nothing but the topic is disconnected
+	if n := streamer.ClientCount(); n != 3 {
+		t.Errorf("expected 3 clients, got: %d", n)
+	}
+	expected := map[string]int{"room.2": 2, "room.*": 1}
+	if topics := streamer.Topics(); !reflect.DeepEqual(topics, expected) {
+		t.Errorf("expected %v, got: %v", expected, topics)
+	}
+
+	// new clients can no longer subscribe to the topic
+	w := NewMockChanWriteFlusher()
+	r, cancel2 := NewMockRequest()
+	r.URL.RawQuery = "topic=room.1"
+	defer serve(t, streamer, w, r, cancel2)()
+
+	streamer.SendStringTo("room.1", "", "", "after")
+	streamer.SendStringTo("room.2", "", "", "room 2")
+	streamer.SendString("", "", "all")
+
+	for query, events := range map[string][]string{
+		"topic=room.1":        {"data:before\n\n", "event:closed\ndata\n\n"},
+		"topic=room.1,room.2": {"data:before\n\n", "event:closed\ndata\n\n", "data:room 2\n\n", "data:all\n\n"},
+		"topic=room.*":        {"data:before\n\n", "event:closed\ndata\n\n", "data:after\n\n", "data:room 2\n\n", "data:all\n\n"},
+		"topic=room.2":        {"data:room 2\n\n", "data:all\n\n"},
+	} {
+		for _, e := range events {
+			if got := recv(t, writers[query].writes); got != e {
+				t.Errorf("%q: expected %q, got: %q", query, e, got)
+			}
+		}
+	}
+	if got := recv(t, w.writes); got != "data:all\n\n" {
+		t.Errorf("new client: expected only the broadcast, got: %q", got)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// TraceParentHeader is the request header of the W3C Trace Context, which
+// carries the trace ID of distributed traces.
+const TraceParentHeader = "traceparent"
+
+// WithTraceID enables extracting a trace ID from the given request header of
+// each connecting client, to correlate the connection with distributed traces.
+// The trace ID is available to all functions receiving the request of the
+// client, e.g. WithAuthorizer, WithOnClientGone or an Observer, with TraceID,
+// and is included in the logs of WithBufferingCheck.
+// If header is TraceParentHeader, the trace-id field is extracted from it,
+// otherwise the whole header value is the trace ID.
+// If echo is not empty, the trace ID is sent back in the response header
+// with that name.
+func WithTraceID(header, echo string) Option {
+	return func(s *Streamer) {
+		s.traceHeader = http.CanonicalHeaderKey(header)
+		s.traceEcho = echo
+	}
+}
+
+type traceIDKey struct{}
+
+// TraceID returns the trace ID of the request of a client, see WithTraceID.
+func TraceID(r *http.Request) string {
+	id, _ := r.Context().Value(traceIDKey{}).(string)
+	return id
+}
+
+// withTraceID extracts the trace ID of the request and adds it to the
+// context of the request, see WithTraceID.
+func (s *Streamer) withTraceID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(s.traceHeader)
+	if s.traceHeader == http.CanonicalHeaderKey(TraceParentHeader) {
+		id = parseTraceParent(id)
+	}
+	if id == "" {
+		return r
+	}
+	if s.traceEcho != "" {
+		w.Header().Set(s.traceEcho, id)
+	}
+	return r.WithContext(context.WithValue(r.Context(), traceIDKey{}, id))
+}
+
+// parseTraceParent returns the trace-id field of a traceparent header value,
+// e.g. "0af7651916cd43dd8448eb211c80319c" of
+// "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01".
+// It returns an empty string if the value is malformed.
+func parseTraceParent(value string) string {
+	fields := strings.Split(value, "-")
+	if len(fields) < 4 || len(fields[1]) != 32 {
+		return ""
+	}
+	return fields[1]
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	var tests = []struct {
+		value string
+		id    string
+	}{
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "0af7651916cd43dd8448eb211c80319c"},
+		{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331", ""},
+		{"00-0af7651916-b7ad6b7169203331-01", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if id := parseTraceParent(test.value); id != test.id {
+			t.Errorf("%q: expected %q, got: %q", test.value, test.id, id)
+		}
+	}
+}
+
+// traceObserver records the trace IDs of the connecting clients.
+type traceObserver chan string
+
+func (o traceObserver) Connect(r *http.Request) func() {
+	o <- TraceID(r)
+	return nil
+}
+
+func (o traceObserver) Broadcast(clients int) {}
+
+func TestTraceID(t *testing.T) {
+	var tests = []struct {
+		header string
+		value  string
+		id     string
+	}{
+		{TraceParentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "0af7651916cd43dd8448eb211c80319c"},
+		{TraceParentHeader, "malformed", ""},
+		{"X-Request-ID", "abc123", "abc123"},
+	}
+	for _, test := range tests {
+		observer := make(traceObserver, 1)
+		streamer := MustNew(WithTraceID(test.header, "X-Trace-ID"), WithObserver(observer))
+		w := NewMockChanWriteFlusher()
+		r, cancel := NewMockRequest()
+		r.Header.Set(test.header, test.value)
+		stop := serve(t, streamer, w, r, cancel)
+
+		if id := <-observer; id != test.id {
+			t.Errorf("%s %q: expected trace ID %q in the callback, got: %q", test.header, test.value, test.id, id)
+		}
+		if id := w.Header().Get("X-Trace-ID"); id != test.id {
+			t.Errorf("%s %q: expected trace ID %q in the response header, got: %q", test.header, test.value, test.id, id)
+		}
+		stop()
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package sse
+
+// SendTyped sends an event with the given value encoded as JSON to all
+// connected clients, like SendJSON. Unlike SendJSON, the type of the value is
+// checked at compile time, e.g. by instantiating it for a fixed event type:
+//
+//	sendUpdate := sse.SendTyped[Update]
+//	sendUpdate(s, "", "update", Update{...})
+//
+// This requires Go 1.18 or newer.
+func SendTyped[T any](s *Streamer, id, event string, v T) error {
+	return s.SendJSON(id, event, v)
+}
+
+// TypedSender sends events with a fixed payload type T.
+type TypedSender[T any] struct {
+	s     *Streamer
+	event string
+}
+
+// NewTypedSender returns a TypedSender, which sends events with the given event
+// type and a payload of type T via the given Streamer.
+// This requires Go 1.18 or newer.
+func NewTypedSender[T any](s *Streamer, event string) *TypedSender[T] {
+	return &TypedSender[T]{s: s, event: event}
+}
+
+// Send sends an event with the given value encoded as JSON to all connected
+// clients. If the id is empty, no id is send.
+func (t *TypedSender[T]) Send(id string, v T) error {
+	return SendTyped(t.s, id, t.event, v)
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package sse
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+type priceUpdate struct {
+	Symbol string  `json:"symbol"`
+	Price  float64 `json:"price"`
+}
+
+func TestSendTyped(t *testing.T) {
+	var marshaled int
+	streamer := MustNew(WithJSONMarshaler(func(v interface{}) ([]byte, error) {
+		marshaled++
+		return json.Marshal(v)
+	}))
+	w := NewMockChanWriteFlusher()
+	r, cancel := NewMockRequest()
+	stop := serve(t, streamer, w, r, cancel)
+	defer stop()
+
+	if err := SendTyped(streamer, "1", "price", priceUpdate{"GO", 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	if got := recv(t, w.writes); got != "id:1\nevent:price\ndata:{\"symbol\":\"GO\",\"price\":1.5}\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+
+	prices := NewTypedSender[priceUpdate](streamer, "price")
+	if err := prices.Send("", priceUpdate{"SSE", 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := recv(t, w.writes); got != "event:price\ndata:{\"symbol\":\"SSE\",\"price\":2}\n\n" {
+		t.Errorf("wrong event, got: %q", got)
+	}
+
+	if marshaled != 2 {
+		t.Errorf("expected the configured marshaler to be used 2 times, got: %d", marshaled)
+	}
+}
+
+func ExampleNewTypedSender() {
+	type Update struct {
+		Symbol string
+		Price  float64
+	}
+
+	streamer := MustNew()
+	updates := NewTypedSender[Update](streamer, "update")
+
+	http.Handle("/prices", streamer)
+	go func() {
+		// only values of type Update can be sent
+		updates.Send("", Update{Symbol: "GO", Price: 1.18})
+	}()
+}
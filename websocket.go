@@ -0,0 +1,75 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"time"
+)
+
+// WSConn is a WebSocket connection over which events can be streamed with
+// ServeWebSocket. It lets any WebSocket library be used without this package
+// depending on it. For example, a connection of github.com/gorilla/websocket
+// can be adapted like this:
+//
+//	type wsConn struct {
+//		conn *websocket.Conn
+//		done chan struct{}
+//	}
+//
+//	func (c wsConn) WriteMessage(p []byte) error {
+//		return c.conn.WriteMessage(websocket.TextMessage, p)
+//	}
+//
+//	func (c wsConn) Done() <-chan struct{} {
+//		return c.done
+//	}
+//
+//	func serveWS(w http.ResponseWriter, r *http.Request) {
+//		conn, err := upgrader.Upgrade(w, r, nil)
+//		if err != nil {
+//			return
+//		}
+//		defer conn.Close()
+//
+//		c := wsConn{conn, make(chan struct{})}
+//		go func() {
+//			// read to process control messages until the connection is closed
+//			defer close(c.done)
+//			for {
+//				if _, _, err := conn.NextReader(); err != nil {
+//					return
+//				}
+//			}
+//		}()
+//		streamer.ServeWebSocket(c)
+//	}
+type WSConn interface {
+	// WriteMessage writes p as a single text message.
+	WriteMessage(p []byte) error
+
+	// Done returns a channel which is closed when the connection is closed.
+	Done() <-chan struct{}
+}
+
+// ServeWebSocket streams events over a WebSocket connection, for networks
+// which break long-lived HTTP responses but allow WebSockets. The connection
+// receives the same events as the HTTP clients, each written as a single
+// message in the Server-Sent Events format, which the client has to parse
+// itself. Heartbeats are sent with the interval set by WithHeartbeat.
+// ServeWebSocket blocks until the connection is closed, writing to it fails or
+// the Streamer is closed. It does not close the connection.
+func (s *Streamer) ServeWebSocket(conn WSConn) {
+	cl := s.newClient()
+	initial, _ := s.connect(cl, "")
+	defer s.disconnect(cl)
+
+	var heartbeat <-chan time.Time
+	if s.heartbeat > 0 {
+		ticker := time.NewTicker(s.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	s.writeEvents(cl, initial, conn.WriteMessage, conn.Done(), heartbeat)
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 Julien Schmidt. All rights reserved.
+// Use of this source code is governed by MIT license,
+// a copy can be found in the LICENSE file.
+
+package sse
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// mockWSConn passes every message to the messages channel.
+type mockWSConn struct {
+	messages chan string
+	done     chan struct{}
+	err      error
+}
+
+func (c *mockWSConn) WriteMessage(p []byte) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.messages <- string(p)
+	return nil
+}
+
+func (c *mockWSConn) Done() <-chan struct{} {
+	return c.done
+}
+
+func newMockWSConn() *mockWSConn {
+	return &mockWSConn{
+		messages: make(chan string, 100),
+		done:     make(chan struct{}),
+	}
+}
+
+// serveWebSocket runs ServeWebSocket in a new goroutine and waits until the
+// connection is registered. The returned channel is closed when
+// ServeWebSocket returned.
+func serveWebSocket(t *testing.T, s *Streamer, conn WSConn) (done chan struct{}) {
+	n := s.Stats().Clients
+	done = make(chan struct{})
+	go func() {
+		s.ServeWebSocket(conn)
+		close(done)
+	}()
+	waitForClients(t, s, n+1)
+	return done
+}
+
+func waitDone(t *testing.T, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeWebSocket did not return")
+	}
+}
+
+func TestServeWebSocket(t *testing.T) {
+	streamer := MustNew()
+	conn := newMockWSConn()
+	done := serveWebSocket(t, streamer, conn)
+
+	streamer.SendString("1", "msg", "hello")
+	streamer.SendString("", "", "multi\nline")
+	for _, expected := range []string{
+		"id:1\nevent:msg\ndata:hello\n\n",
+		"data:multi\ndata:line\n\n",
+	} {
+		if got := recv(t, conn.messages); got != expected {
+			t.Errorf("expected message %q, got: %q", expected, got)
+		}
+	}
+
+	// the client is disconnected when the connection is closed
+	close(conn.done)
+	waitDone(t, done)
+	if n := streamer.Stats().Clients; n != 0 {
+		t.Error("expected 0 clients, has:", n)
+	}
+}
+
+func TestServeWebSocketWriteError(t *testing.T) {
+	streamer := MustNew()
+	conn := newMockWSConn()
+	conn.err = errors.New("broken pipe")
+	done := serveWebSocket(t, streamer, conn)
+
+	streamer.SendString("", "", "event")
+	waitDone(t, done)
+	if n := streamer.Stats().Clients; n != 0 {
+		t.Error("expected 0 clients, has:", n)
+	}
+}
+
+func TestServeWebSocketHeartbeat(t *testing.T) {
+	streamer := MustNew(WithHeartbeat(10 * time.Millisecond))
+	conn := newMockWSConn()
+	done := serveWebSocket(t, streamer, conn)
+
+	if got := recv(t, conn.messages); got != ":\n\n" {
+		t.Errorf("expected heartbeat, got: %q", got)
+	}
+	streamer.Close()
+	waitDone(t, done)
+}